@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/stenstromen/miniomatic/db"
+)
+
+// Operations an API key can be allowed to perform
+const (
+	OpRead   = "read"
+	OpCreate = "create"
+	OpResize = "resize"
+	OpDelete = "delete"
+	OpAdmin  = "admin"
+)
+
+// Operations lists every operation, in the order they are documented
+var Operations = []string{OpRead, OpCreate, OpResize, OpDelete, OpAdmin}
+
+// ValidOperation reports whether op is a known operation
+func ValidOperation(op string) bool {
+	return contains(Operations, op)
+}
+
+// Roles bundle the operations granted to a kind of caller
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleOperations = map[string][]string{
+	RoleViewer:   {OpRead},
+	RoleOperator: {OpRead, OpCreate, OpResize},
+	RoleAdmin:    {OpAdmin},
+}
+
+// Roles lists every role, from least to most privileged
+var Roles = []string{RoleViewer, RoleOperator, RoleAdmin}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := roleOperations[role]
+	return ok
+}
+
+// grants returns the operations of a role merged with extra operations
+func grants(role string, extra []string) []string {
+	operations := append([]string{}, roleOperations[role]...)
+	for _, op := range extra {
+		if !contains(operations, op) {
+			operations = append(operations, op)
+		}
+	}
+	return operations
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// keyPrefix marks keys issued by miniomatic
+const keyPrefix = "mm_"
+
+var (
+	// ErrUnauthenticated is returned when a request carries no valid credentials
+	ErrUnauthenticated = errors.New("unauthorized")
+	// ErrExpired is returned for credentials past their expiry
+	ErrExpired = errors.New("credentials expired")
+)
+
+// Principal is the caller of a request. An empty Tenant is global and sees
+// the instances of every tenant.
+type Principal struct {
+	KeyID      string
+	Name       string
+	Tenant     string
+	Role       string
+	Operations []string
+}
+
+// Identity returns a stable identifier of the principal, the key ID for API
+// keys and the subject for tokens
+func (p *Principal) Identity() string {
+	switch {
+	case p.KeyID != "":
+		return "key:" + p.KeyID
+	case p.Name == "API_KEY":
+		return "key:API_KEY"
+	}
+	return "token:" + p.Name
+}
+
+// String identifies the principal in logs
+func (p *Principal) String() string {
+	caller := "token " + p.Name
+	if p.KeyID != "" || p.Name == "API_KEY" {
+		caller = "key " + p.Name
+	}
+	if p.Tenant != "" {
+		caller += " of tenant " + p.Tenant
+	}
+	return caller
+}
+
+// Allows reports whether the principal may perform an operation. Admin
+// allows every operation.
+func (p *Principal) Allows(op string) bool {
+	for _, allowed := range p.Operations {
+		if allowed == op || allowed == OpAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Owns reports whether the principal can see resources of a tenant
+func (p *Principal) Owns(tenant string) bool {
+	return p.Tenant == "" || p.Tenant == tenant
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a context carrying the caller of a request
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the caller of a request, nil outside of authenticated requests
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+// NewAPIKey returns a new random API key and the hash it is stored under
+func NewAPIKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hash an API key is stored under. Keys are random
+// and long, so a fast hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthenticateAPIKey resolves an API key to its principal. The API_KEY
+// environment variable remains a global admin key for bootstrapping.
+func AuthenticateAPIKey(key string) (*Principal, error) {
+	if key == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	if envKey := os.Getenv("API_KEY"); envKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(envKey)) == 1 {
+		return &Principal{Name: "API_KEY", Role: RoleAdmin, Operations: grants(RoleAdmin, nil)}, nil
+	}
+
+	apiKey, err := db.GetAPIKeyByHash(HashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil {
+		return nil, ErrUnauthenticated
+	}
+	if apiKey.Expires != "" {
+		expires, err := time.Parse(time.RFC3339, apiKey.Expires)
+		if err != nil || time.Now().After(expires) {
+			return nil, ErrExpired
+		}
+	}
+
+	return &Principal{
+		KeyID:      apiKey.ID,
+		Name:       apiKey.Name,
+		Tenant:     apiKey.Tenant,
+		Role:       apiKey.Role,
+		Operations: grants(apiKey.Role, apiKey.Operations),
+	}, nil
+}
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// clockSkew is the leeway allowed when checking token lifetimes
+const clockSkew = time.Minute
+
+// OIDCEnabled reports whether bearer tokens are accepted
+func OIDCEnabled() bool {
+	return os.Getenv("OIDC_ISSUER") != ""
+}
+
+// AuthenticateToken verifies a JWT bearer token against the configured
+// issuer, audience and key set and maps its claims to a principal. The
+// tenant and roles are read from the claims named by OIDC_TENANT_CLAIM and
+// OIDC_ROLES_CLAIM, which may be dotted paths into nested claims.
+func AuthenticateToken(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed token header", ErrUnauthenticated)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token signature", ErrUnauthenticated)
+	}
+
+	key, err := jwks.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed token claims", ErrUnauthenticated)
+	}
+	if err := validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	tenant, err := tokenTenant(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// The claim may hold roles, operations or both. The most privileged
+	// role wins and operations are granted on top of it.
+	var role string
+	var operations []string
+	for _, value := range claimStrings(claim(claims, envOrDefault("OIDC_ROLES_CLAIM", "roles"))) {
+		if ValidRole(value) && rank(value) > rank(role) {
+			role = value
+		}
+		if ValidOperation(value) {
+			operations = append(operations, value)
+		}
+	}
+
+	return &Principal{Name: subject, Tenant: tenant, Role: role, Operations: grants(role, operations)}, nil
+}
+
+// tokenTenant returns the tenant of a token. Every token needs a tenant, a
+// token is only global when its tenant is OIDC_GLOBAL_TENANT, so a missing
+// claim can't grant access to every tenant.
+func tokenTenant(claims map[string]interface{}) (string, error) {
+	name := envOrDefault("OIDC_TENANT_CLAIM", "tenant")
+	tenant, _ := claim(claims, name).(string)
+	if tenant == "" {
+		return "", fmt.Errorf("%w: token has no %s claim", ErrUnauthenticated, name)
+	}
+	if global := os.Getenv("OIDC_GLOBAL_TENANT"); global != "" && tenant == global {
+		return "", nil
+	}
+	return tenant, nil
+}
+
+func validateClaims(claims map[string]interface{}, now time.Time) error {
+	if iss, _ := claims["iss"].(string); iss != os.Getenv("OIDC_ISSUER") {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrUnauthenticated, iss)
+	}
+
+	// The audience is always checked so tokens issued to other clients of
+	// the same issuer are rejected
+	audience, found := os.Getenv("OIDC_AUDIENCE"), false
+	for _, aud := range claimStrings(claims["aud"]) {
+		if aud != "" && aud == audience {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: token is not meant for %q", ErrUnauthenticated, audience)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: token has no expiry", ErrUnauthenticated)
+	}
+	if now.Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return ErrExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: token is not valid yet", ErrUnauthenticated)
+	}
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+	default:
+		return fmt.Errorf("%w: unsupported signing algorithm %q", ErrUnauthenticated, alg)
+	}
+
+	var valid bool
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' && alg[0] != 'P' {
+			break
+		}
+		h := hash.New()
+		h.Write(signed)
+		if alg[0] == 'R' {
+			valid = rsa.VerifyPKCS1v15(k, hash, h.Sum(nil), signature) == nil
+		} else {
+			valid = rsa.VerifyPSS(k, hash, h.Sum(nil), signature, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || alg == "EdDSA" || len(signature) != 2*size {
+			break
+		}
+		h := hash.New()
+		h.Write(signed)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		valid = ecdsa.Verify(k, h.Sum(nil), r, s)
+	case ed25519.PublicKey:
+		valid = alg == "EdDSA" && ed25519.Verify(k, signed, signature)
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: invalid token signature", ErrUnauthenticated)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// claim returns the claim at a dotted path such as realm_access.roles
+func claim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// claimStrings returns a claim holding a string array, a single string or
+// a space separated list like scope
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func envOrDefault(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// rank orders roles by privilege, -1 for no role
+func rank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,396 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://sso.example.com"
+	testAudience = "miniomatic"
+)
+
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed      ed25519.PrivateKey
+	jwksDir string
+}
+
+// setupIssuer generates throwaway signing keys, writes their public keys to
+// an OIDC_JWKS_FILE and configures the issuer
+func setupIssuer(t *testing.T) *testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &testKeys{rsa: rsaKey, ec: ecKey, ed: edKey, jwksDir: t.TempDir()}
+
+	keys.writeJWKS(t,
+		jsonWebKey{Kty: "RSA", Kid: "rsa", Use: "sig", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		jsonWebKey{Kty: "EC", Kid: "ec", Crv: "P-256", X: b64(ecKey.X.FillBytes(make([]byte, 32))), Y: b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		jsonWebKey{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: b64(edKey.Public().(ed25519.PublicKey))},
+	)
+
+	t.Setenv("OIDC_ISSUER", testIssuer)
+	t.Setenv("OIDC_AUDIENCE", testAudience)
+	t.Setenv("OIDC_JWKS_FILE", filepath.Join(keys.jwksDir, "jwks.json"))
+	t.Setenv("OIDC_TENANT_CLAIM", "")
+	t.Setenv("OIDC_ROLES_CLAIM", "")
+	t.Setenv("OIDC_GLOBAL_TENANT", "")
+	resetJWKS()
+	t.Cleanup(resetJWKS)
+	return keys
+}
+
+func (k *testKeys) writeJWKS(t *testing.T, keys ...jsonWebKey) {
+	t.Helper()
+	data, err := json.Marshal(map[string][]jsonWebKey{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(k.jwksDir, "jwks.json"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func resetJWKS() {
+	jwks.mu.Lock()
+	defer jwks.mu.Unlock()
+	jwks.keys, jwks.loaded = nil, time.Time{}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign returns a token with the given header and claims, signed with the
+// key matching alg
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	var hash crypto.Hash
+	switch alg[len(alg)-3:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+
+	var signature []byte
+	var err error
+	switch {
+	case alg == "EdDSA":
+		signature = ed25519.Sign(k.ed, []byte(signed))
+	case strings.HasPrefix(alg, "RS"):
+		h := hash.New()
+		h.Write([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, hash, h.Sum(nil))
+	case strings.HasPrefix(alg, "PS"):
+		h := hash.New()
+		h.Write([]byte(signed))
+		signature, err = rsa.SignPSS(rand.Reader, k.rsa, hash, h.Sum(nil), nil)
+	case strings.HasPrefix(alg, "ES"):
+		h := hash.New()
+		h.Write([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, h.Sum(nil))
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case alg == "HS256":
+		sum := sha256.Sum256([]byte(signed))
+		signature = sum[:]
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(signature)
+}
+
+func validClaims(overrides map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":    testIssuer,
+		"aud":    testAudience,
+		"sub":    "alice",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"tenant": "acme",
+		"roles":  []string{"operator"},
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+func TestAuthenticateTokenSignatures(t *testing.T) {
+	keys := setupIssuer(t)
+
+	tests := []struct {
+		name string
+		alg  string
+		kid  string
+		ok   bool
+	}{
+		{"RS256", "RS256", "rsa", true},
+		{"RS512", "RS512", "rsa", true},
+		{"PS256", "PS256", "rsa", true},
+		{"ES256", "ES256", "ec", true},
+		{"EdDSA", "EdDSA", "ed", true},
+		{"HMAC is not accepted", "HS256", "rsa", false},
+		{"RSA signature with an EC key", "RS256", "ec", false},
+		{"EC algorithm with an RSA key", "ES256", "rsa", false},
+		{"EdDSA with an RSA key", "EdDSA", "rsa", false},
+		{"unknown kid", "RS256", "other", false},
+		{"empty kid with several keys", "RS256", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := keys.sign(t, tt.alg, tt.kid, validClaims(nil))
+			// Tokens naming a key of another type are signed with the key
+			// matching the algorithm, so only the kid mismatch is tested
+			_, err := AuthenticateToken(token)
+			if tt.ok && err != nil {
+				t.Fatalf("expected token to be accepted, got %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrUnauthenticated) {
+				t.Fatalf("expected ErrUnauthenticated, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateTokenTampered(t *testing.T) {
+	keys := setupIssuer(t)
+	token := keys.sign(t, "RS256", "rsa", validClaims(nil))
+	parts := strings.Split(token, ".")
+
+	forged, _ := json.Marshal(validClaims(map[string]interface{}{"tenant": "other"}))
+	none, _ := json.Marshal(map[string]string{"alg": "none", "kid": "rsa"})
+
+	tests := map[string]string{
+		"changed claims":    parts[0] + "." + b64(forged) + "." + parts[2],
+		"alg none":          b64(none) + "." + parts[1] + ".",
+		"missing signature": parts[0] + "." + parts[1],
+		"not base64":        parts[0] + "." + parts[1] + ".!!!",
+		"empty":             "",
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := AuthenticateToken(token); !errors.Is(err, ErrUnauthenticated) {
+				t.Fatalf("expected ErrUnauthenticated, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateTokenClaims(t *testing.T) {
+	keys := setupIssuer(t)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   error
+	}{
+		{"valid", nil, nil},
+		{"audience in a list", map[string]interface{}{"aud": []string{"other", testAudience}}, nil},
+		{"other audience", map[string]interface{}{"aud": "other"}, ErrUnauthenticated},
+		{"no audience", map[string]interface{}{"aud": nil}, ErrUnauthenticated},
+		{"other issuer", map[string]interface{}{"iss": "https://evil.example.com"}, ErrUnauthenticated},
+		{"no issuer", map[string]interface{}{"iss": nil}, ErrUnauthenticated},
+		{"no expiry", map[string]interface{}{"exp": nil}, ErrUnauthenticated},
+		{"expired", map[string]interface{}{"exp": now.Add(-2 * clockSkew).Unix()}, ErrExpired},
+		{"expired within the skew", map[string]interface{}{"exp": now.Add(-clockSkew / 2).Unix()}, nil},
+		{"not valid yet", map[string]interface{}{"nbf": now.Add(2 * clockSkew).Unix()}, ErrUnauthenticated},
+		{"not valid yet within the skew", map[string]interface{}{"nbf": now.Add(clockSkew / 2).Unix()}, nil},
+		{"no tenant", map[string]interface{}{"tenant": nil}, ErrUnauthenticated},
+		{"empty tenant", map[string]interface{}{"tenant": ""}, ErrUnauthenticated},
+		{"tenant is not a string", map[string]interface{}{"tenant": []string{"acme"}}, ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AuthenticateToken(keys.sign(t, "RS256", "rsa", validClaims(tt.claims)))
+			if tt.want == nil && err != nil {
+				t.Fatalf("expected token to be accepted, got %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateTokenAudienceRequired(t *testing.T) {
+	keys := setupIssuer(t)
+	t.Setenv("OIDC_AUDIENCE", "")
+
+	token := keys.sign(t, "RS256", "rsa", validClaims(map[string]interface{}{"aud": ""}))
+	if _, err := AuthenticateToken(token); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated without OIDC_AUDIENCE, got %v", err)
+	}
+}
+
+func TestAuthenticateTokenPrincipal(t *testing.T) {
+	keys := setupIssuer(t)
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		claims     map[string]interface{}
+		tenant     string
+		role       string
+		operations []string
+	}{
+		{
+			name:       "operator",
+			tenant:     "acme",
+			role:       RoleOperator,
+			operations: []string{OpRead, OpCreate, OpResize},
+		},
+		{
+			name:       "most privileged role wins",
+			claims:     map[string]interface{}{"roles": []string{"viewer", "admin", "operator"}},
+			tenant:     "acme",
+			role:       RoleAdmin,
+			operations: []string{OpAdmin},
+		},
+		{
+			name:       "operations on top of a role",
+			claims:     map[string]interface{}{"roles": "viewer delete unknown"},
+			tenant:     "acme",
+			role:       RoleViewer,
+			operations: []string{OpRead, OpDelete},
+		},
+		{
+			name:   "no roles",
+			claims: map[string]interface{}{"roles": nil},
+			tenant: "acme",
+		},
+		{
+			name:       "nested claims",
+			env:        map[string]string{"OIDC_TENANT_CLAIM": "org.id", "OIDC_ROLES_CLAIM": "realm_access.roles"},
+			claims:     map[string]interface{}{"org": map[string]string{"id": "initech"}, "realm_access": map[string][]string{"roles": {"viewer"}}},
+			tenant:     "initech",
+			role:       RoleViewer,
+			operations: []string{OpRead},
+		},
+		{
+			name:       "global tenant",
+			env:        map[string]string{"OIDC_GLOBAL_TENANT": "*"},
+			claims:     map[string]interface{}{"tenant": "*"},
+			tenant:     "",
+			role:       RoleOperator,
+			operations: []string{OpRead, OpCreate, OpResize},
+		},
+		{
+			name:       "global tenant value without opt-in",
+			claims:     map[string]interface{}{"tenant": "*"},
+			tenant:     "*",
+			role:       RoleOperator,
+			operations: []string{OpRead, OpCreate, OpResize},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			p, err := AuthenticateToken(keys.sign(t, "ES256", "ec", validClaims(tt.claims)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Name != "alice" || p.Tenant != tt.tenant || p.Role != tt.role || strings.Join(p.Operations, ",") != strings.Join(tt.operations, ",") {
+				t.Fatalf("got %+v, want tenant %q role %q operations %v", p, tt.tenant, tt.role, tt.operations)
+			}
+		})
+	}
+}
+
+func TestKeySetRefresh(t *testing.T) {
+	keys := setupIssuer(t)
+	token := keys.sign(t, "RS256", "rotated", validClaims(nil))
+
+	if _, err := AuthenticateToken(token); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected unknown kid to be rejected, got %v", err)
+	}
+
+	// The issuer rotates its keys, the set is reloaded once the minimum
+	// refresh interval has passed
+	keys.writeJWKS(t, jsonWebKey{Kty: "RSA", Kid: "rotated", N: b64(keys.rsa.N.Bytes()), E: b64(big.NewInt(int64(keys.rsa.E)).Bytes())})
+	if _, err := AuthenticateToken(token); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected the key set not to be reloaded yet, got %v", err)
+	}
+	jwks.mu.Lock()
+	jwks.loaded = time.Now().Add(-2 * jwksMinRefresh)
+	jwks.mu.Unlock()
+	if _, err := AuthenticateToken(token); err != nil {
+		t.Fatalf("expected rotated key to be picked up, got %v", err)
+	}
+
+	// A set with a single key matches tokens without a kid
+	if _, err := AuthenticateToken(keys.sign(t, "RS256", "", validClaims(nil))); err != nil {
+		t.Fatalf("expected the only key to match an empty kid, got %v", err)
+	}
+}
+
+func TestKeySetLoad(t *testing.T) {
+	keys := setupIssuer(t)
+
+	tests := []struct {
+		name string
+		key  jsonWebKey
+		ok   bool
+	}{
+		{"encryption keys are skipped", jsonWebKey{Kty: "RSA", Kid: "enc", Use: "enc", N: "!!!"}, true},
+		{"unsupported key type", jsonWebKey{Kty: "oct", Kid: "oct"}, false},
+		{"unsupported curve", jsonWebKey{Kty: "EC", Kid: "ec", Crv: "P-224"}, false},
+		{"point not on the curve", jsonWebKey{Kty: "EC", Kid: "ec", Crv: "P-256", X: b64([]byte{1}), Y: b64([]byte{2})}, false},
+		{"short Ed25519 key", jsonWebKey{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: b64([]byte{1, 2, 3})}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys.writeJWKS(t, tt.key)
+			resetJWKS()
+			jwks.mu.Lock()
+			err := jwks.load()
+			jwks.mu.Unlock()
+			if tt.ok != (err == nil) {
+				t.Fatalf("expected ok %v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/auth"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/model"
+	"github.com/stenstromen/miniomatic/rnd"
+)
+
+// CreateAPIKey issues a new API key. The key is only part of this response.
+// Tenant admins can only issue keys for their own tenant.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var post model.APIKey
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if post.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+	if tenant := requestTenant(r); tenant != "" {
+		if post.Tenant != "" && post.Tenant != tenant {
+			respondWithError(w, http.StatusForbidden, "Keys can only be issued for tenant "+tenant)
+			return
+		}
+		post.Tenant = tenant
+	}
+	if post.Role != "" && !auth.ValidRole(post.Role) {
+		respondWithError(w, http.StatusBadRequest, "Invalid role "+post.Role+". Expected viewer, operator or admin")
+		return
+	}
+	if post.Role == "" && len(post.Operations) == 0 {
+		respondWithError(w, http.StatusBadRequest, "A role or at least one operation is required")
+		return
+	}
+	for _, op := range post.Operations {
+		if !auth.ValidOperation(op) {
+			respondWithError(w, http.StatusBadRequest, "Invalid operation "+op+". Expected read, create, resize, delete or admin")
+			return
+		}
+	}
+	if post.Expires != "" {
+		expires, err := time.Parse(time.RFC3339, post.Expires)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid expires. Expected an RFC 3339 timestamp")
+			return
+		}
+		if expires.Before(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "Expires must be in the future")
+			return
+		}
+	}
+
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	post.ID, post.Created = rnd.RandomString(false, 8), time.Now().Format("2006-01-02 15:04:05")
+	if err := db.InsertAPIKey(post, hash); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	post.Key = key
+	respondWithJSON(w, http.StatusCreated, post)
+}
+
+// ListAPIKeys lists the API keys of the caller's tenant, or all keys for global admins
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := db.GetAPIKeys(requestTenant(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// DeleteAPIKey revokes an API key of the caller's tenant
+func DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, err := db.GetAPIKey(mux.Vars(r)["keyId"])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if key == nil || !auth.FromContext(r.Context()).Owns(key.Tenant) {
+		respondWithError(w, http.StatusNotFound, "No API key found")
+		return
+	}
+
+	if err := db.DeleteAPIKey(key.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/k8sclient"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func GetAutoscalePolicy(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	policy, err := db.GetAutoscalePolicy(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if policy == nil {
+		respondWithError(w, http.StatusNotFound, "No autoscale policy found")
+		return
+	}
+
+	if policy.History, err = db.GetEvents(id, db.EventAutoscaled); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+func SetAutoscalePolicy(w http.ResponseWriter, r *http.Request) {
+	var post model.AutoscalePolicy
+	id := mux.Vars(r)["id"]
+
+	record, err := db.GetDataByID(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		respondWithError(w, http.StatusNotFound, "No record found")
+		return
+	}
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	_ = json.NewDecoder(r.Body).Decode(&post)
+
+	if post.ThresholdPercent < 1 || post.ThresholdPercent > 99 {
+		respondWithError(w, http.StatusBadRequest, "Invalid threshold percent. Expected a value between 1 and 99")
+		return
+	}
+	if !validateStorageFormat(post.Step) || !validateStorageFormat(post.MaxStorage) {
+		respondWithError(w, http.StatusBadRequest, "Invalid step or max storage format. Expected format: [Number][Ki|Mi|Gi]")
+		return
+	}
+	step, err := resource.ParseQuantity(post.Step)
+	if err != nil || step.Value() <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid step value")
+		return
+	}
+	if _, err := resource.ParseQuantity(post.MaxStorage); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid max storage value")
+		return
+	}
+
+	post.ID, post.History = id, nil
+	if err := db.SetAutoscalePolicy(id, post); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, post)
+}
+
+func DeleteAutoscalePolicy(w http.ResponseWriter, r *http.Request) {
+	if err := db.DeleteAutoscalePolicy(mux.Vars(r)["id"]); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartAutoscaler checks the usage of instances with an autoscale policy on
+// every interval and grows their storage when usage crosses the threshold
+func StartAutoscaler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			policies, err := db.GetAutoscalePolicies()
+			if err != nil {
+				log.Printf("Error getting autoscale policies: %v", err)
+				continue
+			}
+			for _, policy := range policies {
+				if err := autoscale(policy); err != nil {
+					log.Printf("Error autoscaling ID %s: %v", policy.ID, err)
+				}
+			}
+		}
+	}()
+}
+
+func autoscale(policy model.AutoscalePolicy) error {
+	record, err := db.GetDataByID(policy.ID)
+	if err != nil {
+		return err
+	}
+	if record == nil || record.Status != "ready" {
+		return nil
+	}
+
+	requested, err := resource.ParseQuantity(record.Storage)
+	if err != nil {
+		return fmt.Errorf("invalid storage in record: %v", err)
+	}
+	capacity, err := k8sclient.GetPVCCapacity(policy.ID)
+	if err != nil {
+		return err
+	}
+	// A previous resize has not reached the filesystem yet
+	if capacity.Cmp(requested) < 0 {
+		return nil
+	}
+
+	creds, err := k8sclient.GetMinioCredentials(policy.ID)
+	if err != nil {
+		return err
+	}
+	usage, err := madmin.DataUsage(creds)
+	if err != nil {
+		return err
+	}
+	percent := float64(usage.TotalBytes) / float64(capacity.Value()) * 100
+	if percent < float64(policy.ThresholdPercent) {
+		return nil
+	}
+
+	step, maxStorage := resource.MustParse(policy.Step), resource.MustParse(policy.MaxStorage)
+	if requested.Cmp(maxStorage) >= 0 {
+		return nil
+	}
+	storage := requested.DeepCopy()
+	storage.Add(step)
+	if storage.Cmp(maxStorage) > 0 {
+		storage = maxStorage
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if code, msg := checkQuota(record.Tenant, record.ID, storage); code != 0 {
+		return fmt.Errorf("not resizing to %s: %s", storage.String(), msg)
+	}
+
+	if err := resizeInstance(record, storage); errors.Is(err, k8sclient.ErrPVCUnchanged) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return db.InsertEvent(policy.ID, db.EventAutoscaled, fmt.Sprintf("Resized from %s to %s at %.1f%% usage", requested.String(), storage.String(), percent))
+}
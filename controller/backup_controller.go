@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+	"github.com/stenstromen/miniomatic/rnd"
+)
+
+const (
+	backupJob  = "backup"
+	restoreJob = "restore"
+)
+
+// startBackup records a backup job for an instance and runs it in the
+// background. Objects are stored under <id>/<timestamp>/ on the backup target.
+// scheduleID is set for backups taken by a backup schedule.
+func startBackup(creds model.Credentials, scheduleID string) (model.BackupJob, error) {
+	now := time.Now()
+	job := model.BackupJob{
+		ID:         rnd.RandomString(false, 8),
+		InstanceID: creds.RandNum,
+		Type:       backupJob,
+		Prefix:     creds.RandNum + "/" + now.UTC().Format("20060102T150405Z"),
+		Status:     "running",
+		Date:       now.Format("2006-01-02 15:04:05"),
+		ScheduleID: scheduleID,
+	}
+	if err := db.InsertBackupJob(job); err != nil {
+		return model.BackupJob{}, err
+	}
+
+	go runBackupJob(job, func(progress func(objects, bytes int64)) error {
+		return madmin.Backup(creds, job.Prefix, progress)
+	})
+	return job, nil
+}
+
+// startRestore records a job restoring a backup into an instance and runs it
+// in the background
+func startRestore(backup model.BackupJob, creds model.Credentials) (model.BackupJob, error) {
+	job := model.BackupJob{
+		ID:         rnd.RandomString(false, 8),
+		InstanceID: creds.RandNum,
+		Type:       restoreJob,
+		BackupID:   backup.ID,
+		Prefix:     backup.Prefix,
+		Status:     "running",
+		Date:       time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if err := db.InsertBackupJob(job); err != nil {
+		return model.BackupJob{}, err
+	}
+
+	go runBackupJob(job, func(progress func(objects, bytes int64)) error {
+		return madmin.Restore(creds, job.Prefix, progress)
+	})
+	return job, nil
+}
+
+func runBackupJob(job model.BackupJob, run func(progress func(objects, bytes int64)) error) {
+	progress := func(objects, bytes int64) {
+		if err := db.UpdateBackupJobProgress(job.ID, objects, bytes); err != nil {
+			log.Printf("Error updating %s job %s: %v", job.Type, job.ID, err)
+		}
+	}
+
+	status, jobErr := "completed", ""
+	if err := run(progress); err != nil {
+		log.Printf("Error running %s job %s for ID %s: %v", job.Type, job.ID, job.InstanceID, err)
+		status, jobErr = "failed", err.Error()
+	}
+	if err := db.FinishBackupJob(job.ID, status, jobErr); err != nil {
+		log.Printf("Error updating %s job %s: %v", job.Type, job.ID, err)
+	}
+}
+
+// completedBackup looks up a backup that can be restored from, returning a
+// non-zero status code when it can't be used
+func completedBackup(id string) (*model.BackupJob, int, string) {
+	backup, err := db.GetBackupJob(id)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err.Error()
+	}
+	if backup == nil || backup.Type != backupJob {
+		return nil, http.StatusNotFound, "No backup found"
+	}
+	if backup.Status != "completed" {
+		return nil, http.StatusConflict, "Backup is " + backup.Status + ", only completed backups can be restored"
+	}
+	return backup, 0, ""
+}
+
+func CreateBackup(w http.ResponseWriter, r *http.Request) {
+	if !madmin.BackupConfigured() {
+		respondWithError(w, http.StatusServiceUnavailable, madmin.ErrBackupNotConfigured.Error())
+		return
+	}
+
+	creds, ok := instanceCredentials(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	job, err := startBackup(creds, "")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// ListBackups lists the backups of an instance. Backups are kept on the
+// backup target, so they are still listed after the instance is deleted.
+func ListBackups(w http.ResponseWriter, r *http.Request) {
+	listBackupJobs(w, mux.Vars(r)["id"], backupJob)
+}
+
+func GetBackup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if job, ok := instanceBackupJob(w, vars["id"], vars["backupId"], backupJob); ok {
+		respondWithJSON(w, http.StatusOK, job)
+	}
+}
+
+func DeleteBackup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	backup, ok := instanceBackupJob(w, vars["id"], vars["backupId"], backupJob)
+	if !ok {
+		return
+	}
+	if backup.Status == "running" {
+		respondWithError(w, http.StatusConflict, "Backup is still running")
+		return
+	}
+
+	if err := madmin.DeleteBackup(backup.Prefix); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := db.DeleteBackupJob(backup.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreBackup restores a backup into the instance given in the body, or
+// into the instance the backup was taken from
+func RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	var post model.RestorePost
+	vars := mux.Vars(r)
+
+	if !madmin.BackupConfigured() {
+		respondWithError(w, http.StatusServiceUnavailable, madmin.ErrBackupNotConfigured.Error())
+		return
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	backup, code, msg := completedBackup(vars["backupId"])
+	if code == 0 && backup.InstanceID != vars["id"] {
+		code, msg = http.StatusNotFound, "No backup found"
+	}
+	if code != 0 {
+		respondWithError(w, code, msg)
+		return
+	}
+
+	target := post.Instance
+	if target == "" {
+		target = vars["id"]
+	}
+	creds, ok := ownedInstanceCredentials(w, r, target)
+	if !ok {
+		return
+	}
+
+	job, err := startRestore(*backup, creds)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// ListRestores lists the jobs that restored a backup into an instance
+func ListRestores(w http.ResponseWriter, r *http.Request) {
+	listBackupJobs(w, mux.Vars(r)["id"], restoreJob)
+}
+
+func GetRestore(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if job, ok := instanceBackupJob(w, vars["id"], vars["jobId"], restoreJob); ok {
+		respondWithJSON(w, http.StatusOK, job)
+	}
+}
+
+func listBackupJobs(w http.ResponseWriter, id, jobType string) {
+	jobs, err := db.GetBackupJobs(id, jobType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, jobs)
+}
+
+// instanceBackupJob looks up a job of an instance and writes a 404 when it
+// doesn't exist, is of another type or belongs to another instance
+func instanceBackupJob(w http.ResponseWriter, id, jobID, jobType string) (*model.BackupJob, bool) {
+	job, err := db.GetBackupJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if job == nil || job.Type != jobType || job.InstanceID != id {
+		respondWithError(w, http.StatusNotFound, "No "+jobType+" found")
+		return nil, false
+	}
+	return job, true
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// validateBucketOptions returns an error message for invalid bucket options
+func validateBucketOptions(opts model.BucketOptions) string {
+	if opts.RetentionMode == "" {
+		if opts.RetentionValidity != 0 || opts.RetentionUnit != "" {
+			return "Retention validity and unit require a retention mode"
+		}
+		return ""
+	}
+	if !opts.ObjectLock {
+		return "Default retention requires object locking"
+	}
+	if opts.RetentionMode != "GOVERNANCE" && opts.RetentionMode != "COMPLIANCE" {
+		return "Invalid retention mode. Expected GOVERNANCE or COMPLIANCE"
+	}
+	if opts.RetentionUnit != "DAYS" && opts.RetentionUnit != "YEARS" {
+		return "Invalid retention unit. Expected DAYS or YEARS"
+	}
+	if opts.RetentionValidity == 0 {
+		return "Retention validity must be greater than 0"
+	}
+	return ""
+}
+
+func respondWithBucketError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, madmin.ErrBucketNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, madmin.ErrBucketExists), errors.Is(err, madmin.ErrBucketNotEmpty):
+		respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func GetBuckets(w http.ResponseWriter, r *http.Request) {
+	creds, ok := instanceCredentials(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	buckets, err := madmin.ListBuckets(creds)
+	if err != nil {
+		respondWithBucketError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, buckets)
+}
+
+func GetBucket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	bucket, err := madmin.GetBucket(creds, vars["bucket"])
+	if err != nil {
+		respondWithBucketError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, bucket)
+}
+
+func CreateBucket(w http.ResponseWriter, r *http.Request) {
+	var post model.BucketPost
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	_ = json.NewDecoder(r.Body).Decode(&post)
+
+	if post.Bucket == "" {
+		respondWithError(w, http.StatusBadRequest, "Bucket name is required")
+		return
+	}
+	if msg := validateBucketOptions(post.BucketOptions); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	var quota resource.Quantity
+	if post.Quota != "" {
+		var err error
+		if !validateStorageFormat(post.Quota) {
+			respondWithError(w, http.StatusBadRequest, "Invalid quota format. Expected format: [Number][Ki|Mi|Gi]")
+			return
+		}
+		if quota, err = resource.ParseQuantity(post.Quota); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid quota value")
+			return
+		}
+	}
+
+	creds, ok := instanceCredentials(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	if err := madmin.MakeBucket(creds, post.Bucket, post.BucketOptions); err != nil {
+		respondWithBucketError(w, err)
+		return
+	}
+	if quota.Value() > 0 {
+		if err := madmin.SetBucketQuota(creds, post.Bucket, uint64(quota.Value())); err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	bucket, err := madmin.GetBucket(creds, post.Bucket)
+	if err != nil {
+		respondWithBucketError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, bucket)
+}
+
+func DeleteBucket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	if err := madmin.RemoveBucket(creds, vars["bucket"]); err != nil {
+		respondWithBucketError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
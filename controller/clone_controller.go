@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/k8sclient"
+	"github.com/stenstromen/miniomatic/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// cloneSource is the data a new instance is cloned from
+type cloneSource struct {
+	dataSource *corev1.TypedLocalObjectReference
+	// storage is the size of the source volume, the clone can't be smaller
+	storage resource.Quantity
+	// storageClass is the class the clone has to use, empty when any class works
+	storageClass string
+	// namespace is where the source lives, data sources can't cross namespaces
+	namespace string
+	// rootUser is the root user of the source instance, empty when it is gone
+	rootUser string
+}
+
+// resolveCloneSource looks up the snapshot or instance a new instance is
+// cloned from. It returns nil when the instance starts empty, and a non-zero
+// status code when the source can't be used.
+func resolveCloneSource(r *http.Request, post model.Post) (*cloneSource, int, string) {
+	sources := 0
+	for _, source := range []string{post.SourceSnapshot, post.SourceInstance, post.SourceBackup} {
+		if source != "" {
+			sources++
+		}
+	}
+
+	switch {
+	case sources > 1:
+		return nil, http.StatusBadRequest, "Only one of sourcesnapshot, sourceinstance and sourcebackup can be set"
+
+	// A clone has to live in the namespace of its source, which a new
+	// instance never does when every instance gets a namespace of its own
+	case (post.SourceSnapshot != "" || post.SourceInstance != "") && k8sclient.InstanceNamespaces():
+		return nil, http.StatusUnprocessableEntity, "Cloning from a snapshot or instance is not supported with NAMESPACE_MODE instance, use sourcebackup"
+
+	case post.SourceSnapshot != "":
+		snapshot, err := db.GetSnapshot(post.SourceSnapshot)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err.Error()
+		}
+		if snapshot == nil {
+			return nil, http.StatusNotFound, "No snapshot found"
+		}
+		if !ownsTenant(r, snapshot.Tenant) {
+			return nil, http.StatusNotFound, "No snapshot found"
+		}
+		if err := k8sclient.GetVolumeSnapshotStatus(snapshot); err != nil {
+			return nil, http.StatusInternalServerError, err.Error()
+		}
+		if !snapshot.ReadyToUse {
+			return nil, http.StatusConflict, "Snapshot is not ready to use"
+		}
+
+		storage, err := resource.ParseQuantity(snapshot.Storage)
+		if err != nil {
+			return nil, http.StatusInternalServerError, "Invalid snapshot storage value"
+		}
+		// Snapshots taken before the root user was recorded fall back to
+		// the instance, while it still exists
+		rootUser := snapshot.RootUser
+		if rootUser == "" {
+			rootUser = sourceRootUser(snapshot.InstanceID)
+		}
+		return &cloneSource{
+			dataSource: k8sclient.SnapshotDataSource(snapshot.ID),
+			storage:    storage,
+			namespace:  snapshot.Namespace,
+			rootUser:   rootUser,
+		}, 0, ""
+
+	case post.SourceInstance != "":
+		record, err := db.GetDataByID(post.SourceInstance)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err.Error()
+		}
+		if owned, err := ownsInstance(r, post.SourceInstance); err != nil {
+			return nil, http.StatusInternalServerError, err.Error()
+		} else if record == nil || !owned {
+			return nil, http.StatusNotFound, "No record found for source instance"
+		}
+		if record.Status == "provisioning" {
+			return nil, http.StatusConflict, "Source instance is still provisioning"
+		}
+
+		storage, err := resource.ParseQuantity(record.Storage)
+		if err != nil {
+			return nil, http.StatusInternalServerError, "Invalid source instance storage value"
+		}
+		dataSource, err := k8sclient.InstanceDataSource(record.ID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err.Error()
+		}
+		// CSI volume cloning only works within a storage class
+		return &cloneSource{
+			dataSource:   dataSource,
+			storage:      storage,
+			storageClass: record.StorageClass,
+			namespace:    record.Namespace,
+			rootUser:     sourceRootUser(record.ID),
+		}, 0, ""
+	}
+	return nil, 0, ""
+}
+
+// sourceRootUser returns the root user of a clone source, whose service
+// accounts are copied to the clone. It is empty when the instance no longer
+// exists.
+func sourceRootUser(id string) string {
+	creds, err := k8sclient.GetMinioCredentials(id)
+	if err != nil {
+		return ""
+	}
+	return creds.RootUser
+}
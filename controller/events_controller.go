@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/db"
+)
+
+// GetInstanceEvents returns the history of an instance in chronological
+// order, optionally limited to a comma separated list of event types. The
+// history is kept after the instance is deleted.
+func GetInstanceEvents(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var types []string
+	if raw := r.URL.Query().Get("type"); raw != "" {
+		types = strings.Split(raw, ",")
+	}
+
+	events, err := db.GetEvents(id, types...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(events) == 0 {
+		record, err := db.GetDataByID(id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if record == nil {
+			respondWithError(w, http.StatusNotFound, "No record found")
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,252 @@
+package controller
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// zstdMagic starts every zstd frame, used to detect compressed imports
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// defaultImportMaxSize is the largest archive ImportItem accepts unless
+// IMPORT_MAX_SIZE is set
+const defaultImportMaxSize = "10Gi"
+
+// errArchiveTooLarge is returned when an uploaded or decompressed archive
+// is larger than IMPORT_MAX_SIZE
+var errArchiveTooLarge = errors.New("archive exceeds IMPORT_MAX_SIZE")
+
+// importMaxSize returns IMPORT_MAX_SIZE in bytes, falling back to the
+// default when it is unset or invalid
+func importMaxSize() int64 {
+	size, err := resource.ParseQuantity(os.Getenv("IMPORT_MAX_SIZE"))
+	if err != nil || size.Sign() <= 0 {
+		size = resource.MustParse(defaultImportMaxSize)
+	}
+	return size.Value()
+}
+
+// ExportItem streams an instance as a tar archive: instance.json with the
+// instance definition and bucket settings, followed by the bucket objects.
+// ?format=tar.zst compresses the archive with zstd.
+func ExportItem(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "tar"
+	}
+	if format != "tar" && format != "tar.zst" {
+		respondWithError(w, http.StatusBadRequest, "Invalid format. Expected tar or tar.zst")
+		return
+	}
+
+	creds, ok := instanceCredentials(w, id)
+	if !ok {
+		return
+	}
+	record, err := db.GetDataByID(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	buckets, err := madmin.ExportBuckets(creds)
+	if err != nil {
+		respondWithBucketError(w, err)
+		return
+	}
+	manifest, err := json.MarshalIndent(model.Export{Instance: *record, Buckets: buckets}, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var out io.Writer = w
+	if format == "tar.zst" {
+		w.Header().Set("Content-Type", "application/zstd")
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer encoder.Close()
+		out = encoder
+	} else {
+		w.Header().Set("Content-Type", "application/x-tar")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+"."+format+`"`)
+
+	// Errors past this point can only be logged, the archive is truncated
+	tw := tar.NewWriter(out)
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     madmin.ExportManifest,
+		Size:     int64(len(manifest)),
+		Mode:     0o644,
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		log.Printf("Error exporting ID %s: %v", id, err)
+		return
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		log.Printf("Error exporting ID %s: %v", id, err)
+		return
+	}
+	if err := madmin.ExportObjects(creds, buckets, tw); err != nil {
+		log.Printf("Error exporting ID %s: %v", id, err)
+		return
+	}
+	if err := tw.Close(); err != nil {
+		log.Printf("Error exporting ID %s: %v", id, err)
+	}
+}
+
+// ImportItem creates a new instance from an archive made by ExportItem. The
+// archive, plain or zstd compressed, is stored in a temporary file until the
+// instance is provisioned and its buckets and objects are imported.
+// ?storage and ?storageclass override the size and class of the instance.
+func ImportItem(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+
+	maxSize := importMaxSize()
+	archive, err := spoolArchive(http.MaxBytesReader(w, r.Body, maxSize), maxSize)
+	if errors.Is(err, errArchiveTooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	export, err := readManifest(archive)
+	if err != nil {
+		os.Remove(archive)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	post := model.Post{
+		Storage:      export.Instance.Storage,
+		Bucket:       export.Instance.InitBucket,
+		QuotaPercent: export.Instance.QuotaPercent,
+		StorageClass: r.URL.Query().Get("storageclass"),
+	}
+	if storage := r.URL.Query().Get("storage"); storage != "" {
+		post.Storage = storage
+	}
+	for _, bucket := range export.Buckets {
+		if bucket.Name == post.Bucket {
+			post.BucketOptions = bucket.BucketOptions
+		}
+	}
+
+	started := createInstance(w, r, post, func(creds model.Credentials) {
+		if err := importArchive(creds, archive, export.Buckets); err != nil {
+			log.Printf("Error importing into ID %s: %v", creds.RandNum, err)
+			db.FailInstance(creds.RandNum, fmt.Errorf("import failed: %v", err))
+			return
+		}
+		db.UpdateStatus(creds.RandNum, "ready")
+	}, func() {
+		os.Remove(archive)
+	})
+	if !started {
+		os.Remove(archive)
+	}
+}
+
+// spoolArchive writes an uploaded archive to a temporary file, decompressing
+// it when it is zstd compressed, and returns the file name. Archives larger
+// than maxSize once decompressed are rejected with errArchiveTooLarge.
+func spoolArchive(body io.Reader, maxSize int64) (string, error) {
+	reader := bufio.NewReader(body)
+	var in io.Reader = reader
+	if magic, _ := reader.Peek(len(zstdMagic)); bytes.Equal(magic, zstdMagic) {
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return "", err
+		}
+		defer decoder.Close()
+		in = decoder
+	}
+
+	file, err := os.CreateTemp("", "miniomatic-import-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.LimitReader(in, maxSize+1))
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) || written > maxSize {
+		os.Remove(file.Name())
+		return "", errArchiveTooLarge
+	} else if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("%w: %v", madmin.ErrInvalidArchive, err)
+	}
+	return file.Name(), nil
+}
+
+// readManifest reads instance.json, the first entry of an archive
+func readManifest(archive string) (model.Export, error) {
+	var export model.Export
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return export, err
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	header, err := tr.Next()
+	if err != nil || header.Name != madmin.ExportManifest {
+		return export, fmt.Errorf("%w: %s must be the first entry", madmin.ErrInvalidArchive, madmin.ExportManifest)
+	}
+	if err := json.NewDecoder(tr).Decode(&export); err != nil {
+		return export, fmt.Errorf("%w: %v", madmin.ErrInvalidArchive, err)
+	}
+	return export, nil
+}
+
+func importArchive(creds model.Credentials, archive string, buckets []model.BucketExport) error {
+	if err := db.UpdateStatus(creds.RandNum, "importing"); err != nil {
+		return err
+	}
+	if err := madmin.ImportBuckets(creds, buckets); err != nil {
+		return err
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	if _, err := tr.Next(); err != nil {
+		return err
+	}
+	return madmin.ImportObjects(creds, tr)
+}
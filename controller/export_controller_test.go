@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSpoolArchive(t *testing.T) {
+	plain := bytes.Repeat([]byte("miniomatic"), 100)
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := encoder.EncodeAll(plain, nil)
+
+	tests := []struct {
+		name    string
+		body    []byte
+		maxSize int64
+		tooBig  bool
+	}{
+		{"plain", plain, 1000, false},
+		{"plain too large", plain, 999, true},
+		{"zstd", compressed, 1000, false},
+		{"zstd decompressed too large", compressed, 999, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := spoolArchive(bytes.NewReader(tt.body), tt.maxSize)
+			if tt.tooBig {
+				if !errors.Is(err, errArchiveTooLarge) {
+					t.Fatalf("expected errArchiveTooLarge, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(archive)
+			if got, _ := os.ReadFile(archive); !bytes.Equal(got, plain) {
+				t.Fatalf("spooled %d bytes, want %d", len(got), len(plain))
+			}
+		})
+	}
+
+	t.Run("request body too large", func(t *testing.T) {
+		body := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(bytes.NewReader(compressed)), int64(len(compressed)-1))
+		if _, err := spoolArchive(body, 1000); !errors.Is(err, errArchiveTooLarge) {
+			t.Fatalf("expected errArchiveTooLarge, got %v", err)
+		}
+	})
+}
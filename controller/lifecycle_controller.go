@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+func isXML(contentType string) bool {
+	return strings.Contains(contentType, "/xml")
+}
+
+func respondWithLifecycleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, madmin.ErrInvalidLifecycle) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithBucketError(w, err)
+}
+
+func respondWithLifecycle(w http.ResponseWriter, r *http.Request, config *lifecycle.Configuration) {
+	if isXML(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		xml.NewEncoder(w).Encode(config)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, model.LifecyclePost{Rules: madmin.LifecycleRules(config)})
+}
+
+func GetBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	config, err := madmin.GetBucketLifecycle(creds, vars["bucket"])
+	if err != nil {
+		respondWithLifecycleError(w, err)
+		return
+	}
+	respondWithLifecycle(w, r, config)
+}
+
+func SetBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var config *lifecycle.Configuration
+	if isXML(r.Header.Get("Content-Type")) {
+		config, err = madmin.LifecycleFromXML(body)
+	} else {
+		var post model.LifecyclePost
+		if err := json.Unmarshal(body, &post); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if len(post.Rules) == 0 {
+			respondWithError(w, http.StatusBadRequest, "At least one rule is required")
+			return
+		}
+		config, err = madmin.LifecycleFromRules(post.Rules)
+	}
+	if err != nil {
+		respondWithLifecycleError(w, err)
+		return
+	}
+
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	if err := madmin.SetBucketLifecycle(creds, vars["bucket"], config); err != nil {
+		respondWithLifecycleError(w, err)
+		return
+	}
+
+	config, err = madmin.GetBucketLifecycle(creds, vars["bucket"])
+	if err != nil {
+		respondWithLifecycleError(w, err)
+		return
+	}
+	respondWithLifecycle(w, r, config)
+}
+
+func DeleteBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	if err := madmin.SetBucketLifecycle(creds, vars["bucket"], lifecycle.NewConfiguration()); err != nil {
+		respondWithLifecycleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
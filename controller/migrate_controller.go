@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/k8sclient"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// validateStorageClass checks a requested StorageClass against the
+// STORAGECLASS_ALLOWLIST and the classes present in the cluster. A non-zero
+// status code is returned when the class can't be used.
+func validateStorageClass(name string) (int, string) {
+	if allowlist := os.Getenv("STORAGECLASS_ALLOWLIST"); allowlist != "" {
+		allowed := false
+		for _, class := range strings.Split(allowlist, ",") {
+			if strings.TrimSpace(class) == name {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return http.StatusUnprocessableEntity, "Storage class " + name + " is not allowed"
+		}
+	}
+
+	exists, err := k8sclient.StorageClassExists(name)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	if !exists {
+		return http.StatusUnprocessableEntity, "Storage class " + name + " does not exist"
+	}
+	return 0, ""
+}
+
+func MigrateItem(w http.ResponseWriter, r *http.Request) {
+	var post model.MigratePost
+	id := mux.Vars(r)["id"]
+
+	record, err := db.GetDataByID(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		respondWithError(w, http.StatusNotFound, "No record found")
+		return
+	}
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil || post.StorageClass == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body. Expected storageclass")
+		return
+	}
+
+	if record.Status != "ready" {
+		respondWithError(w, http.StatusConflict, "Instance is "+record.Status+", migration requires a ready instance")
+		return
+	}
+	if post.StorageClass == record.StorageClass {
+		respondWithError(w, http.StatusConflict, "Instance already uses storage class "+post.StorageClass)
+		return
+	}
+	if code, msg := validateStorageClass(post.StorageClass); code != 0 {
+		respondWithError(w, code, msg)
+		return
+	}
+
+	if err := db.UpdateStatus(id, "migrating"); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	go func(from string) {
+		if err := k8sclient.MigrateStorageClass(id, post.StorageClass); err != nil {
+			log.Printf("failed to migrate instance %s to storage class %s: %v", id, post.StorageClass, err)
+			db.FailInstance(id, fmt.Errorf("migration to storage class %s failed: %v", post.StorageClass, err))
+			return
+		}
+		db.InsertEvent(id, db.EventMigrated, "Migrated from storage class "+from+" to "+post.StorageClass)
+		db.UpdateStatus(id, "ready")
+	}(record.StorageClass)
+
+	record.Status, record.StorageClass = "migrating", post.StorageClass
+	respondWithJSON(w, http.StatusAccepted, record)
+}
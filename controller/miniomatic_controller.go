@@ -2,6 +2,8 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -14,6 +16,7 @@ import (
 	"github.com/stenstromen/miniomatic/madmin"
 	"github.com/stenstromen/miniomatic/model"
 	"github.com/stenstromen/miniomatic/rnd"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
 
@@ -23,6 +26,33 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// instanceCredentials looks up the record for id and returns the root
+// credentials of the instance, writing an error response on failure
+func instanceCredentials(w http.ResponseWriter, id string) (model.Credentials, bool) {
+	record, err := db.GetDataByID(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return model.Credentials{}, false
+	}
+	if record == nil {
+		respondWithError(w, http.StatusNotFound, "No record found")
+		return model.Credentials{}, false
+	}
+
+	creds, err := k8sclient.GetMinioCredentials(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return model.Credentials{}, false
+	}
+	return creds, true
+}
+
 func validateStorageFormat(storage string) bool {
 	validStorageFormat := regexp.MustCompile(`^[0-9]+(Ki|Mi|Gi)$`)
 	return validStorageFormat.MatchString(storage)
@@ -34,6 +64,15 @@ func GetItems(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if tenant := requestTenant(r); tenant != "" {
+		var owned []model.Record
+		for _, item := range items {
+			if item.Tenant == tenant {
+				owned = append(owned, item)
+			}
+		}
+		items = owned
+	}
 	if len(items) == 0 {
 		respondWithError(w, http.StatusNotFound, "No records found")
 		return
@@ -56,6 +95,22 @@ func GetItem(w http.ResponseWriter, r *http.Request) {
 
 func CreateItem(w http.ResponseWriter, r *http.Request) {
 	var post model.Post
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+
+	_ = json.NewDecoder(r.Body).Decode(&post)
+
+	createInstance(w, r, post, nil, nil)
+}
+
+// createInstance validates post, responds with the details of the new
+// instance and provisions it in the background. afterProvision, when set,
+// runs once the instance is set up and is responsible for marking it ready. cleanup, when set, runs when provisioning
+// ends, whether it failed or not. It reports whether provisioning started,
+// cleanup is left to the caller when it didn't.
+func createInstance(w http.ResponseWriter, r *http.Request, post model.Post, afterProvision func(creds model.Credentials), cleanup func()) bool {
 	creds := model.Credentials{
 		RandNum:      rnd.RandomString(false, 6),
 		RootUser:     rnd.RandomString(true, 16),
@@ -68,51 +123,175 @@ func CreateItem(w http.ResponseWriter, r *http.Request) {
 	if StorageClassName == "" {
 		StorageClassName = "local-pv"
 	}
-	if r.ContentLength == 0 {
-		respondWithError(w, http.StatusBadRequest, "Empty request body")
-		return
+
+	source, code, msg := resolveCloneSource(r, post)
+	if code != 0 {
+		respondWithError(w, code, msg)
+		return false
+	}
+	if source != nil && post.Storage == "" {
+		post.Storage = source.storage.String()
 	}
 
-	_ = json.NewDecoder(r.Body).Decode(&post)
+	var backup *model.BackupJob
+	if post.SourceBackup != "" {
+		if !madmin.BackupConfigured() {
+			respondWithError(w, http.StatusServiceUnavailable, madmin.ErrBackupNotConfigured.Error())
+			return false
+		}
+		if backup, code, msg = completedBackup(post.SourceBackup); code != 0 {
+			respondWithError(w, code, msg)
+			return false
+		}
+		if !ownsTenant(r, backup.Tenant) {
+			respondWithError(w, http.StatusNotFound, "No backup found")
+			return false
+		}
+	}
 
 	if !validateStorageFormat(post.Storage) {
 		respondWithError(w, http.StatusBadRequest, "Invalid storage format. Expected format: [Number][Ki|Mi|Gi]")
-		return
+		return false
 	}
 
-	_, err := resource.ParseQuantity(post.Storage)
+	storage, err := resource.ParseQuantity(post.Storage)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid storage value")
-		return
+		return false
+	}
+
+	if source != nil && storage.Cmp(source.storage) < 0 {
+		respondWithError(w, http.StatusUnprocessableEntity, "Storage can't be smaller than the clone source ("+source.storage.String()+")")
+		return false
+	}
+
+	if post.QuotaPercent < 0 || post.QuotaPercent > 100 {
+		respondWithError(w, http.StatusBadRequest, "Invalid quota percent. Expected a value between 0 and 100")
+		return false
+	}
+	quota := quotaFromPercent(storage, post.QuotaPercent)
+
+	if msg := validateBucketOptions(post.BucketOptions); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return false
+	}
+
+	if source != nil && source.storageClass != "" {
+		if post.StorageClass == "" {
+			post.StorageClass = source.storageClass
+		} else if post.StorageClass != source.storageClass {
+			respondWithError(w, http.StatusUnprocessableEntity, "A cloned instance must use the storage class of its source ("+source.storageClass+")")
+			return false
+		}
+	}
+
+	if post.StorageClass != "" {
+		if code, msg := validateStorageClass(post.StorageClass); code != 0 {
+			respondWithError(w, code, msg)
+			return false
+		}
+		StorageClassName = post.StorageClass
+	}
+
+	ns, err := tenantNamespace(requestTenant(r), creds.RandNum)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if source != nil && source.namespace != ns.Name {
+		respondWithError(w, http.StatusUnprocessableEntity, "A cloned instance must run in the namespace of its source ("+source.namespace+")")
+		return false
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if code, msg := checkQuota(requestTenant(r), "", storage); code != 0 {
+		respondWithError(w, code, msg)
+		return false
+	}
+
+	setAuditInstance(r, creds.RandNum)
+	db.InsertData(creds.RandNum, post.Bucket, post.Storage, StorageClassName, requestTenant(r), ns.Name, post.QuotaPercent)
+	if err := db.InsertEvent(creds.RandNum, db.EventCreated, createdMessage(post, StorageClassName, ns.Name)); err != nil {
+		log.Printf("Error recording creation of ID %s: %v", creds.RandNum, err)
 	}
 
 	go func() {
-		err := k8sclient.CreateMinioResources(creds, ClusterIssuer, StorageClassName, post.Storage)
+		if cleanup != nil {
+			defer cleanup()
+		}
+
+		var dataSource *corev1.TypedLocalObjectReference
+		if source != nil {
+			dataSource = source.dataSource
+		}
+		err := k8sclient.CreateMinioResources(creds, ns, ClusterIssuer, StorageClassName, post.Storage, dataSource)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Printf("Error creating resources for ID %s: %v", creds.RandNum, err)
+			db.FailInstance(creds.RandNum, err)
 			return
 		}
 
-		err = madmin.Madmin(creds, post.Bucket, AccessKey, SecretKey)
+		if source != nil {
+			err = madmin.Clone(creds, source.rootUser, post.Bucket, AccessKey, SecretKey, quota, post.BucketOptions)
+		} else {
+			err = madmin.Madmin(creds, post.Bucket, AccessKey, SecretKey, quota, post.BucketOptions)
+		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Printf("Error setting up ID %s: %v", creds.RandNum, err)
+			db.FailInstance(creds.RandNum, err)
 			return
 		}
+
+		// afterProvision moves the instance on to ready itself, so the
+		// instance isn't reported ready before it is done
+		if afterProvision != nil {
+			afterProvision(creds)
+			return
+		}
+		if err := db.UpdateStatus(creds.RandNum, "ready"); err != nil {
+			log.Printf("Error updating status of ID %s: %v", creds.RandNum, err)
+		}
+
+		if backup != nil {
+			if _, err := startRestore(*backup, creds); err != nil {
+				log.Printf("Error restoring backup %s into ID %s: %v", backup.ID, creds.RandNum, err)
+			}
+		}
 	}()
 
 	resp := model.Resp{
-		Status:    "provisioning",
-		ID:        creds.RandNum,
-		Storage:   post.Storage,
-		Bucket:    post.Bucket,
-		URL:       "https://" + creds.RandNum + "." + os.Getenv("WILDCARD_DOMAIN"),
-		AccessKey: AccessKey,
-		SecretKey: SecretKey,
+		Status:       "provisioning",
+		ID:           creds.RandNum,
+		Storage:      post.Storage,
+		Bucket:       post.Bucket,
+		URL:          "https://" + creds.RandNum + "." + os.Getenv("WILDCARD_DOMAIN"),
+		AccessKey:    AccessKey,
+		SecretKey:    SecretKey,
+		StorageClass: StorageClassName,
+		Tenant:       requestTenant(r),
+		Namespace:    ns.Name,
+		QuotaPercent: post.QuotaPercent,
 	}
 
-	db.InsertData(creds.RandNum, post.Bucket, post.Storage)
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(resp)
+	return true
+}
+
+// createdMessage describes a new instance for its history
+func createdMessage(post model.Post, storageClass, namespace string) string {
+	message := fmt.Sprintf("Created with %s of storage class %s in namespace %s", post.Storage, storageClass, namespace)
+	switch {
+	case post.SourceSnapshot != "":
+		message += ", cloned from snapshot " + post.SourceSnapshot
+	case post.SourceInstance != "":
+		message += ", cloned from instance " + post.SourceInstance
+	}
+	if post.SourceBackup != "" {
+		message += ", restoring backup " + post.SourceBackup
+	}
+	return message
 }
 
 func UpdateItem(w http.ResponseWriter, r *http.Request) {
@@ -124,6 +303,10 @@ func UpdateItem(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if InitBucket == nil {
+		respondWithError(w, http.StatusNotFound, "No record found")
+		return
+	}
 	if r.ContentLength == 0 {
 		respondWithError(w, http.StatusBadRequest, "Empty request body")
 		return
@@ -134,30 +317,98 @@ func UpdateItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = resource.ParseQuantity(post.Storage)
+	storage, err := resource.ParseQuantity(post.Storage)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid storage value")
 		return
 	}
 
-	k8sclient.ResizeMinioPVC(ID, post.Storage)
+	if InitBucket.Status != "ready" {
+		respondWithError(w, http.StatusConflict, "Instance is "+InitBucket.Status+", resizing requires a ready instance")
+		return
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if code, msg := checkQuota(InitBucket.Tenant, ID, storage); code != 0 {
+		respondWithError(w, code, msg)
+		return
+	}
 
 	resp := model.Resp{
-		Status:  "resizing",
-		ID:      ID,
-		Storage: post.Storage,
-		Bucket:  InitBucket.InitBucket,
-		URL:     "https://" + ID + "." + os.Getenv("WILDCARD_DOMAIN"),
+		Status:       "resizing",
+		ID:           ID,
+		Storage:      post.Storage,
+		Bucket:       InitBucket.InitBucket,
+		URL:          "https://" + ID + "." + os.Getenv("WILDCARD_DOMAIN"),
+		QuotaPercent: InitBucket.QuotaPercent,
+	}
+
+	if err := resizeInstance(InitBucket, storage); err != nil {
+		switch {
+		case errors.Is(err, k8sclient.ErrPVCUnchanged):
+			resp.Status = InitBucket.Status
+			respondWithJSON(w, http.StatusOK, resp)
+		case errors.Is(err, k8sclient.ErrPVCShrink):
+			respondWithError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, k8sclient.ErrVolumeExpansionNotAllowed):
+			respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if err := db.InsertEvent(ID, db.EventResized, "Resized from "+InitBucket.Storage+" to "+storage.String()); err != nil {
+		log.Printf("Error recording resize of ID %s: %v", ID, err)
 	}
-	db.UpdateData(ID, resp.Bucket, post.Storage)
+
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(resp)
 
 }
 
+// resizeInstance resizes the PVC of an instance and records the new size.
+// The instance is marked ready once the volume has grown, at which point the
+// initial bucket quota is adjusted when it follows the instance size. It
+// returns k8sclient.ErrPVCUnchanged without doing anything when the size
+// stays the same.
+func resizeInstance(record *model.Record, storage resource.Quantity) error {
+	if err := k8sclient.ResizeMinioPVC(record.ID, storage.String()); err != nil {
+		return err
+	}
+	if err := db.UpdateData(record.ID, record.InitBucket, storage.String()); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := k8sclient.WaitForPVCResize(record.ID, storage.String()); err != nil {
+			log.Printf("Error resizing PVC for ID %s: %v", record.ID, err)
+			db.FailInstance(record.ID, err)
+			return
+		}
+		if record.QuotaPercent > 0 {
+			if err := adjustInitBucketQuota(record, storage); err != nil {
+				log.Printf("Error adjusting quota for ID %s: %v", record.ID, err)
+			}
+		}
+	}()
+	return nil
+}
+
 func DeleteItem(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 
+	// The namespace and tenant are only recorded with the instance. Look up
+	// the namespace and record the deletion while the record exists, so the
+	// webhooks of its tenant are notified.
+	namespace := k8sclient.SharedNamespace
+	if record, err := db.GetDataByID(id); err == nil && record != nil {
+		namespace = record.Namespace
+		if err := db.InsertEvent(id, db.EventDeleted, ""); err != nil {
+			log.Printf("Error recording deletion of ID %s: %v", id, err)
+		}
+	}
+
 	err := db.DeleteData(id)
 	if err != nil {
 		if strings.Contains(err.Error(), "no record found with ID") {
@@ -168,13 +419,41 @@ func DeleteItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := db.DeleteAutoscalePolicy(id); err != nil {
+		log.Printf("Error deleting autoscale policy for ID %s: %v", id, err)
+	}
+	deleteInstanceBackupSchedules(id)
+
 	go func() {
-		if err := k8sclient.DeleteMinioResources(id); err != nil {
+		deleteInstanceSnapshots(id)
+		if err := k8sclient.DeleteMinioResources(id, namespace); err != nil {
 			log.Printf("Error deleting resources for ID %s: %v", id, err)
+			db.InsertEvent(id, db.EventFailed, err.Error())
 		}
+		releaseNamespace(namespace)
 	}()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]string{"status": "Deletion in progress"})
 }
+
+// releaseNamespace deletes the namespace of a deleted instance once no
+// instance or retained snapshot lives in it anymore. Shared and tenant
+// namespaces are kept.
+func releaseNamespace(namespace string) {
+	if namespace == k8sclient.SharedNamespace {
+		return
+	}
+	inUse, err := db.NamespaceInUse(namespace)
+	if err != nil {
+		log.Printf("Error checking namespace %s: %v", namespace, err)
+		return
+	}
+	if inUse {
+		return
+	}
+	if err := k8sclient.DeleteInstanceNamespace(namespace); err != nil {
+		log.Printf("Error deleting namespace %s: %v", namespace, err)
+	}
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+	"github.com/stenstromen/miniomatic/rnd"
+)
+
+func respondWithNotificationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, madmin.ErrInvalidNotification):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, madmin.ErrTargetNotFound), errors.Is(err, madmin.ErrNotificationNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		respondWithBucketError(w, err)
+	}
+}
+
+func GetNotificationTargets(w http.ResponseWriter, r *http.Request) {
+	creds, ok := instanceCredentials(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	targets, err := madmin.ListNotificationTargets(creds)
+	if err != nil {
+		respondWithNotificationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, targets)
+}
+
+func SetNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	var post model.NotificationTarget
+	vars := mux.Vars(r)
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	post.Name = vars["name"]
+
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	target, restart, err := madmin.SetNotificationTarget(creds, post)
+	if err != nil {
+		respondWithNotificationError(w, err)
+		return
+	}
+	if restart {
+		go restartForNotifications(creds)
+		respondWithJSON(w, http.StatusAccepted, target)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, target)
+}
+
+func DeleteNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	restart, err := madmin.DeleteNotificationTarget(creds, vars["name"])
+	if err != nil {
+		respondWithNotificationError(w, err)
+		return
+	}
+	if restart {
+		go restartForNotifications(creds)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restartForNotifications restarts an instance so a change to its
+// notification targets applies. It runs after the response, a restart can
+// take minutes.
+func restartForNotifications(creds model.Credentials) {
+	if err := madmin.RestartInstance(creds); err != nil {
+		log.Printf("Error restarting ID %s for notification targets: %v", creds.RandNum, err)
+	}
+}
+
+func GetBucketNotifications(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	notifications, err := madmin.ListBucketNotifications(creds, vars["bucket"])
+	if err != nil {
+		respondWithNotificationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, notifications)
+}
+
+func CreateBucketNotification(w http.ResponseWriter, r *http.Request) {
+	var post model.BucketNotification
+	vars := mux.Vars(r)
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if post.Target == "" {
+		respondWithError(w, http.StatusBadRequest, "Target is required")
+		return
+	}
+	if post.ID == "" {
+		post.ID = rnd.RandomString(false, 8)
+	}
+
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	notification, err := madmin.AddBucketNotification(creds, vars["bucket"], post)
+	if err != nil {
+		respondWithNotificationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, notification)
+}
+
+func DeleteBucketNotification(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	if err := madmin.DeleteBucketNotification(creds, vars["bucket"], vars["notificationId"]); err != nil {
+		respondWithNotificationError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+func respondWithPolicyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, madmin.ErrInvalidPolicy) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithBucketError(w, err)
+}
+
+func GetBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	policy, err := madmin.GetBucketPolicy(creds, vars["bucket"])
+	if err != nil {
+		respondWithPolicyError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+func SetBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	var post model.BucketPolicy
+	vars := mux.Vars(r)
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	hasPolicy := len(post.Policy) > 0 && string(post.Policy) != "null"
+	if (post.Preset == "") == !hasPolicy {
+		respondWithError(w, http.StatusBadRequest, "Expected exactly one of preset or policy")
+		return
+	}
+
+	policy := post.Policy
+	if post.Preset != "" {
+		var err error
+		if policy, err = madmin.PresetPolicy(vars["bucket"], post.Preset); err != nil {
+			respondWithPolicyError(w, err)
+			return
+		}
+	}
+
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	if err := madmin.SetBucketPolicy(creds, vars["bucket"], policy); err != nil {
+		respondWithPolicyError(w, err)
+		return
+	}
+
+	effective, err := madmin.GetBucketPolicy(creds, vars["bucket"])
+	if err != nil {
+		respondWithPolicyError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, effective)
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const (
+	defaultPresignExpiry = time.Hour
+	maxPresignExpiry     = 7 * 24 * time.Hour
+)
+
+func PresignObject(w http.ResponseWriter, r *http.Request) {
+	var post model.PresignPost
+	vars := mux.Vars(r)
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	post.Method = strings.ToUpper(post.Method)
+	if post.Method != "GET" && post.Method != "PUT" && post.Method != "POST" {
+		respondWithError(w, http.StatusBadRequest, "Invalid method. Expected GET, PUT or POST")
+		return
+	}
+	if post.Object == "" {
+		respondWithError(w, http.StatusBadRequest, "Object is required")
+		return
+	}
+	if post.Method != "POST" && (post.ContentType != "" || post.MaxSize != 0) {
+		respondWithError(w, http.StatusBadRequest, "Content type and max size are only supported for POST policies")
+		return
+	}
+	if post.Method != "POST" && strings.HasSuffix(post.Object, "/") {
+		respondWithError(w, http.StatusBadRequest, "Object prefixes are only supported for POST policies")
+		return
+	}
+
+	expiry := defaultPresignExpiry
+	if post.Expiry != 0 {
+		expiry = time.Duration(post.Expiry) * time.Second
+	}
+	if expiry < time.Second || expiry > maxPresignExpiry {
+		respondWithError(w, http.StatusBadRequest, "Invalid expiry. Expected between 1 and 604800 seconds")
+		return
+	}
+
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	presigned, err := madmin.Presign(creds, vars["bucket"], post, expiry)
+	if err != nil {
+		respondWithBucketError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, presigned)
+}
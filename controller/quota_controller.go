@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/k8sclient"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// quotaFromPercent returns percent of the storage quantity in bytes
+func quotaFromPercent(storage resource.Quantity, percent int) uint64 {
+	if percent <= 0 {
+		return 0
+	}
+	return uint64(storage.Value()) * uint64(percent) / 100
+}
+
+// adjustInitBucketQuota recalculates the quota of the initial bucket after the
+// instance storage has been resized
+func adjustInitBucketQuota(record *model.Record, storage resource.Quantity) error {
+	creds, err := k8sclient.GetMinioCredentials(record.ID)
+	if err != nil {
+		return err
+	}
+	return madmin.SetBucketQuota(creds, record.InitBucket, quotaFromPercent(storage, record.QuotaPercent))
+}
+
+func quotaResponse(bucket string, bytes uint64) model.Quota {
+	quota := model.Quota{Bucket: bucket, Bytes: bytes}
+	if bytes > 0 {
+		quota.Quota = resource.NewQuantity(int64(bytes), resource.BinarySI).String()
+	}
+	return quota
+}
+
+func GetBucketQuota(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	bytes, err := madmin.GetBucketQuota(creds, vars["bucket"])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, quotaResponse(vars["bucket"], bytes))
+}
+
+func SetBucketQuota(w http.ResponseWriter, r *http.Request) {
+	var post model.QuotaPost
+	vars := mux.Vars(r)
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	_ = json.NewDecoder(r.Body).Decode(&post)
+
+	if !validateStorageFormat(post.Quota) {
+		respondWithError(w, http.StatusBadRequest, "Invalid quota format. Expected format: [Number][Ki|Mi|Gi]")
+		return
+	}
+	quota, err := resource.ParseQuantity(post.Quota)
+	if err != nil || quota.Value() <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid quota value")
+		return
+	}
+
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	if err := madmin.SetBucketQuota(creds, vars["bucket"], uint64(quota.Value())); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, quotaResponse(vars["bucket"], uint64(quota.Value())))
+}
+
+func DeleteBucketQuota(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	if err := madmin.SetBucketQuota(creds, vars["bucket"], 0); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/k8sclient"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+func respondWithReplicationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, madmin.ErrReplicationExists) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	respondWithBucketError(w, err)
+}
+
+func GetReplication(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	status, err := madmin.GetReplicationStatus(creds, vars["bucket"])
+	if err != nil {
+		respondWithReplicationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, status)
+}
+
+func CreateReplication(w http.ResponseWriter, r *http.Request) {
+	var post model.ReplicationPost
+	vars := mux.Vars(r)
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	_ = json.NewDecoder(r.Body).Decode(&post)
+
+	if post.TargetInstance == "" || post.TargetBucket == "" {
+		respondWithError(w, http.StatusBadRequest, "Target instance and target bucket are required")
+		return
+	}
+	if post.TargetInstance == vars["id"] {
+		respondWithError(w, http.StatusBadRequest, "Target instance must differ from the source instance")
+		return
+	}
+
+	source, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+	target, ok := ownedInstanceCredentials(w, r, post.TargetInstance)
+	if !ok {
+		return
+	}
+
+	status, err := madmin.EnableReplication(source, vars["bucket"], target, post.TargetBucket)
+	if err != nil {
+		respondWithReplicationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, status)
+}
+
+func DeleteReplication(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	accessKeys, err := madmin.DisableReplication(creds, vars["bucket"])
+	if err != nil {
+		respondWithReplicationError(w, err)
+		return
+	}
+	for id, keys := range accessKeys {
+		target, err := k8sclient.GetMinioCredentials(id)
+		if err == nil {
+			err = madmin.RemoveReplicationAccounts(target, keys)
+		}
+		if err != nil {
+			log.Printf("failed to remove replication service accounts on %s: %v", id, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
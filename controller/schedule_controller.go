@@ -0,0 +1,359 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/robfig/cron/v3"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/k8sclient"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+	"github.com/stenstromen/miniomatic/rnd"
+)
+
+const (
+	scheduleBackup   = "backup"
+	scheduleSnapshot = "snapshot"
+
+	// scheduleInterval is how often the scheduler looks for due schedules,
+	// the finest cron resolution
+	scheduleInterval = time.Minute
+
+	// keepFailedBackups is the number of failed backups or snapshots kept per
+	// schedule
+	keepFailedBackups = 3
+)
+
+func ListBackupSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := db.GetBackupSchedules(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, schedules)
+}
+
+func GetBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := instanceBackupSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	runs, err := db.GetScheduleRuns(schedule.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	schedule.Runs = runs
+	respondWithJSON(w, http.StatusOK, schedule)
+}
+
+func CreateBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	record, err := db.GetDataByID(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		respondWithError(w, http.StatusNotFound, "No record found")
+		return
+	}
+
+	saveBackupSchedule(w, r, model.BackupSchedule{ID: rnd.RandomString(false, 8), InstanceID: id}, http.StatusCreated)
+}
+
+func UpdateBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := instanceBackupSchedule(w, r)
+	if !ok {
+		return
+	}
+	saveBackupSchedule(w, r, *schedule, http.StatusOK)
+}
+
+func DeleteBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := instanceBackupSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	if err := db.DeleteBackupSchedule(schedule.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveBackupSchedule validates the schedule in the request body and stores it
+// under the ID and instance of existing
+func saveBackupSchedule(w http.ResponseWriter, r *http.Request, existing model.BackupSchedule, code int) {
+	var post model.BackupSchedule
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	schedule, err := cron.ParseStandard(post.Schedule)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid schedule. Expected cron syntax: "+err.Error())
+		return
+	}
+	switch post.Type {
+	case "":
+		post.Type = scheduleBackup
+	case scheduleBackup, scheduleSnapshot:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid type. Expected backup or snapshot")
+		return
+	}
+	if post.Type == scheduleBackup && !madmin.BackupConfigured() {
+		respondWithError(w, http.StatusServiceUnavailable, madmin.ErrBackupNotConfigured.Error())
+		return
+	}
+	if post.KeepDaily < 0 || post.KeepWeekly < 0 || post.KeepDaily+post.KeepWeekly == 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid retention. Expected keepdaily or keepweekly to be at least 1")
+		return
+	}
+
+	post.ID, post.InstanceID, post.Runs = existing.ID, existing.InstanceID, nil
+	post.NextRun = schedule.Next(time.Now()).Format("2006-01-02 15:04:05")
+	if err := db.SetBackupSchedule(post); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, code, post)
+}
+
+// instanceBackupSchedule looks up the schedule in the request path and writes
+// a 404 when it doesn't exist or belongs to another instance
+func instanceBackupSchedule(w http.ResponseWriter, r *http.Request) (*model.BackupSchedule, bool) {
+	vars := mux.Vars(r)
+
+	schedule, err := db.GetBackupSchedule(vars["scheduleId"])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if schedule == nil || schedule.InstanceID != vars["id"] {
+		respondWithError(w, http.StatusNotFound, "No backup schedule found")
+		return nil, false
+	}
+	return schedule, true
+}
+
+// deleteInstanceBackupSchedules removes the schedules of a deleted instance.
+// What they already took is kept and follows the same rules as manual
+// backups and snapshots.
+func deleteInstanceBackupSchedules(id string) {
+	schedules, err := db.GetBackupSchedules(id)
+	if err != nil {
+		log.Printf("Error getting backup schedules for ID %s: %v", id, err)
+		return
+	}
+	for _, schedule := range schedules {
+		if err := db.DeleteBackupSchedule(schedule.ID); err != nil {
+			log.Printf("Error deleting backup schedule %s: %v", schedule.ID, err)
+		}
+	}
+}
+
+// StartBackupScheduler runs due backup schedules every minute. Schedules and
+// their next run are kept in the database, so runs missed while the server
+// was down happen once on startup.
+func StartBackupScheduler() {
+	go func() {
+		ticker := time.NewTicker(scheduleInterval)
+		defer ticker.Stop()
+
+		for ; true; <-ticker.C {
+			schedules, err := db.GetDueBackupSchedules(time.Now())
+			if err != nil {
+				log.Printf("Error getting backup schedules: %v", err)
+				continue
+			}
+			for _, schedule := range schedules {
+				runBackupSchedule(schedule)
+			}
+		}
+	}()
+}
+
+func runBackupSchedule(schedule model.BackupSchedule) {
+	parsed, err := cron.ParseStandard(schedule.Schedule)
+	if err != nil {
+		log.Printf("Error parsing backup schedule %s: %v", schedule.ID, err)
+		return
+	}
+	if err := db.UpdateBackupScheduleNextRun(schedule.ID, parsed.Next(time.Now())); err != nil {
+		log.Printf("Error updating backup schedule %s: %v", schedule.ID, err)
+		return
+	}
+
+	artifact, runErr := "", ""
+	if id, err := takeScheduledBackup(schedule); err != nil {
+		log.Printf("Error running backup schedule %s for ID %s: %v", schedule.ID, schedule.InstanceID, err)
+		runErr = err.Error()
+	} else {
+		artifact = id
+	}
+	if err := db.InsertScheduleRun(schedule.ID, artifact, runErr); err != nil {
+		log.Printf("Error recording run of backup schedule %s: %v", schedule.ID, err)
+	}
+
+	if err := expireScheduledBackups(schedule); err != nil {
+		log.Printf("Error expiring backups of schedule %s: %v", schedule.ID, err)
+	}
+}
+
+// takeScheduledBackup starts a backup or takes a snapshot for a schedule and
+// returns its ID
+func takeScheduledBackup(schedule model.BackupSchedule) (string, error) {
+	record, err := db.GetDataByID(schedule.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	if record == nil {
+		return "", fmt.Errorf("no record found")
+	}
+	if record.Status != "ready" {
+		return "", fmt.Errorf("instance is %s", record.Status)
+	}
+
+	if schedule.Type == scheduleSnapshot {
+		snapshot, err := createSnapshot(schedule.InstanceID, schedule.ID, false)
+		return snapshot.ID, err
+	}
+
+	creds, err := k8sclient.GetMinioCredentials(schedule.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	job, err := startBackup(creds, schedule.ID)
+	return job.ID, err
+}
+
+// expireScheduledBackups deletes what a schedule took beyond its retention.
+// Running backups and snapshots that aren't ready yet are never expired, and
+// failed ones are pruned apart from the retention.
+func expireScheduledBackups(schedule model.BackupSchedule) error {
+	if schedule.Type == scheduleSnapshot {
+		snapshots, err := db.GetScheduleSnapshots(schedule.ID)
+		if err != nil {
+			return err
+		}
+
+		// Like backups, only snapshots that are ready count towards the
+		// retention
+		var ready, failed []model.Snapshot
+		for _, snapshot := range snapshots {
+			if err := k8sclient.GetVolumeSnapshotStatus(&snapshot); err != nil {
+				return err
+			}
+			switch {
+			case snapshot.Error != "":
+				failed = append(failed, snapshot)
+			case snapshot.ReadyToUse:
+				ready = append(ready, snapshot)
+			}
+		}
+		dates := make([]string, len(ready))
+		for i, snapshot := range ready {
+			dates[i] = snapshot.Date
+		}
+		expire := failed[min(len(failed), keepFailedBackups):]
+		for _, i := range expired(dates, schedule.KeepDaily, schedule.KeepWeekly) {
+			expire = append(expire, ready[i])
+		}
+		for _, snapshot := range expire {
+			if err := k8sclient.DeleteVolumeSnapshot(snapshot.Namespace, snapshot.ID); err != nil {
+				return err
+			}
+			if err := db.DeleteSnapshot(snapshot.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	backups, err := db.GetScheduleBackups(schedule.ID)
+	if err != nil {
+		return err
+	}
+
+	// Only completed backups count towards the retention, failed runs must
+	// not take the place of a good backup
+	var completed, failed []model.BackupJob
+	for _, backup := range backups {
+		switch backup.Status {
+		case "completed":
+			completed = append(completed, backup)
+		case "failed":
+			failed = append(failed, backup)
+		}
+	}
+	dates := make([]string, len(completed))
+	for i, backup := range completed {
+		dates[i] = backup.Date
+	}
+	for _, i := range expired(dates, schedule.KeepDaily, schedule.KeepWeekly) {
+		if err := deleteBackupJob(completed[i]); err != nil {
+			return err
+		}
+	}
+
+	// The most recent failures are kept so they can be looked into, older
+	// ones are removed along with whatever they uploaded
+	for _, backup := range failed[min(len(failed), keepFailedBackups):] {
+		if err := deleteBackupJob(backup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteBackupJob(backup model.BackupJob) error {
+	if err := madmin.DeleteBackup(backup.Prefix); err != nil {
+		return err
+	}
+	return db.DeleteBackupJob(backup.ID)
+}
+
+// expired returns the indexes of dates, sorted newest first, that fall
+// outside the retention. The newest entry of each of the last keepDaily days
+// and keepWeekly ISO weeks is kept.
+func expired(dates []string, keepDaily, keepWeekly int) []int {
+	days, weeks := map[string]bool{}, map[string]bool{}
+
+	var indexes []int
+	for i, date := range dates {
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", date, time.Local)
+		if err != nil {
+			continue
+		}
+		year, week := t.ISOWeek()
+		day, isoWeek := t.Format("2006-01-02"), fmt.Sprintf("%d-%02d", year, week)
+
+		keep := false
+		if !days[day] && len(days) < keepDaily {
+			days[day], keep = true, true
+		}
+		if !weeks[isoWeek] && len(weeks) < keepWeekly {
+			weeks[isoWeek], keep = true, true
+		}
+		if !keep {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/madmin"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+func decodeServiceAccountPost(w http.ResponseWriter, r *http.Request) (model.ServiceAccountPost, bool) {
+	var post model.ServiceAccountPost
+	if r.ContentLength == 0 {
+		return post, true
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return post, false
+	}
+	if len(post.Policy) > 0 && !json.Valid(post.Policy) {
+		respondWithError(w, http.StatusBadRequest, "Invalid policy document")
+		return post, false
+	}
+	if post.Expiration != nil && !post.Expiration.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "Expiration must be in the future")
+		return post, false
+	}
+	if post.Status != "" && post.Status != "on" && post.Status != "off" {
+		respondWithError(w, http.StatusBadRequest, "Invalid status. Expected on or off")
+		return post, false
+	}
+	return post, true
+}
+
+func respondWithServiceAccountError(w http.ResponseWriter, err error) {
+	if errors.Is(err, madmin.ErrServiceAccountNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, err.Error())
+}
+
+// recordCredentials adds a service account change to the instance history
+func recordCredentials(id, message string) {
+	if err := db.InsertEvent(id, db.EventCredentials, message); err != nil {
+		log.Printf("Error recording credential change of ID %s: %v", id, err)
+	}
+}
+
+func GetServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	accounts, err := madmin.ListServiceAccounts(creds, vars["user"])
+	if err != nil {
+		respondWithServiceAccountError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, accounts)
+}
+
+func GetServiceAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	account, err := madmin.GetServiceAccount(creds, vars["user"], vars["accessKey"])
+	if err != nil {
+		respondWithServiceAccountError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, account)
+}
+
+func CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	post, ok := decodeServiceAccountPost(w, r)
+	if !ok {
+		return
+	}
+	if post.Status != "" {
+		respondWithError(w, http.StatusBadRequest, "Status can only be set when updating a service account")
+		return
+	}
+
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	account, err := madmin.AddServiceAccount(creds, vars["user"], post)
+	if err != nil {
+		respondWithServiceAccountError(w, err)
+		return
+	}
+	recordCredentials(vars["id"], "Created service account "+account.AccessKey+" for user "+vars["user"])
+	respondWithJSON(w, http.StatusCreated, account)
+}
+
+func UpdateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	post, ok := decodeServiceAccountPost(w, r)
+	if !ok {
+		return
+	}
+
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	account, err := madmin.UpdateServiceAccount(creds, vars["user"], vars["accessKey"], post)
+	if err != nil {
+		respondWithServiceAccountError(w, err)
+		return
+	}
+	recordCredentials(vars["id"], "Updated service account "+vars["accessKey"]+" of user "+vars["user"])
+	respondWithJSON(w, http.StatusOK, account)
+}
+
+func DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creds, ok := instanceCredentials(w, vars["id"])
+	if !ok {
+		return
+	}
+
+	if err := madmin.DeleteServiceAccount(creds, vars["user"], vars["accessKey"]); err != nil {
+		respondWithServiceAccountError(w, err)
+		return
+	}
+	recordCredentials(vars["id"], "Deleted service account "+vars["accessKey"]+" of user "+vars["user"])
+	w.WriteHeader(http.StatusNoContent)
+}
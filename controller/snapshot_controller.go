@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/k8sclient"
+	"github.com/stenstromen/miniomatic/model"
+	"github.com/stenstromen/miniomatic/rnd"
+)
+
+func CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	var post model.SnapshotPost
+	id := mux.Vars(r)["id"]
+
+	record, err := db.GetDataByID(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		respondWithError(w, http.StatusNotFound, "No record found")
+		return
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	snapshot, err := createSnapshot(id, "", post.Retain)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, snapshot)
+}
+
+// createSnapshot takes a VolumeSnapshot of an instance and records it.
+// scheduleID is set for snapshots taken by a backup schedule.
+func createSnapshot(id, scheduleID string, retain bool) (model.Snapshot, error) {
+	snapshot := model.Snapshot{
+		ID:            id + "-snapshot-" + rnd.RandomString(false, 6),
+		InstanceID:    id,
+		SnapshotClass: os.Getenv("VOLUMESNAPSHOTCLASS"),
+		Retain:        retain,
+		ScheduleID:    scheduleID,
+		// Clones of the snapshot copy the service accounts of this user,
+		// also once the instance is deleted
+		RootUser: sourceRootUser(id),
+	}
+
+	if err := k8sclient.CreateVolumeSnapshot(&snapshot); err != nil {
+		return model.Snapshot{}, err
+	}
+	if err := db.InsertSnapshot(snapshot); err != nil {
+		return model.Snapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots lists the snapshots of an instance. Retained snapshots are
+// still listed after the instance itself is deleted.
+func ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := db.GetSnapshots(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for i := range snapshots {
+		if err := k8sclient.GetVolumeSnapshotStatus(&snapshots[i]); err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, snapshots)
+}
+
+func GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := instanceSnapshot(w, r)
+	if !ok {
+		return
+	}
+
+	if err := k8sclient.GetVolumeSnapshotStatus(snapshot); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, snapshot)
+}
+
+func DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := instanceSnapshot(w, r)
+	if !ok {
+		return
+	}
+
+	if err := k8sclient.DeleteVolumeSnapshot(snapshot.Namespace, snapshot.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := db.DeleteSnapshot(snapshot.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The last retained snapshot of a deleted instance may have kept its
+	// namespace around
+	go releaseNamespace(snapshot.Namespace)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// instanceSnapshot looks up the snapshot in the request path and writes a 404
+// when it doesn't exist or belongs to another instance
+func instanceSnapshot(w http.ResponseWriter, r *http.Request) (*model.Snapshot, bool) {
+	vars := mux.Vars(r)
+
+	snapshot, err := db.GetSnapshot(vars["snapshotId"])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if snapshot == nil || snapshot.InstanceID != vars["id"] {
+		respondWithError(w, http.StatusNotFound, "No snapshot found")
+		return nil, false
+	}
+	return snapshot, true
+}
+
+// deleteInstanceSnapshots removes the snapshots of a deleted instance that
+// were not created with retain
+func deleteInstanceSnapshots(id string) {
+	snapshots, err := db.GetSnapshots(id)
+	if err != nil {
+		log.Printf("Error getting snapshots for ID %s: %v", id, err)
+		return
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Retain {
+			continue
+		}
+		if err := k8sclient.DeleteVolumeSnapshot(snapshot.Namespace, snapshot.ID); err != nil {
+			log.Printf("Error deleting snapshot %s: %v", snapshot.ID, err)
+			continue
+		}
+		if err := db.DeleteSnapshot(snapshot.ID); err != nil {
+			log.Printf("Error deleting snapshot record %s: %v", snapshot.ID, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/auth"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// requestTenant returns the tenant of the caller of a request, empty for
+// global callers
+func requestTenant(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Tenant
+	}
+	return ""
+}
+
+// ownsInstance reports whether the caller of a request may access an
+// instance. Instances of other tenants are treated as not found. Deleted
+// instances belong to the tenant recorded on their snapshots and backups,
+// so tenants can still reach what outlived their instances.
+func ownsInstance(r *http.Request, id string) (bool, error) {
+	p := auth.FromContext(r.Context())
+	if p == nil || p.Tenant == "" {
+		return true, nil
+	}
+
+	tenant, found, err := db.InstanceTenant(id)
+	if err != nil {
+		return false, err
+	}
+	return found && p.Owns(tenant), nil
+}
+
+// ownsTenant reports whether the caller of a request may access resources
+// recorded with a tenant, such as snapshots and backups
+func ownsTenant(r *http.Request, tenant string) bool {
+	p := auth.FromContext(r.Context())
+	return p == nil || p.Owns(tenant)
+}
+
+// InstanceOwnerMiddleware answers 404 for requests on an {id} the caller
+// doesn't own, so instances of other tenants are invisible
+func InstanceOwnerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := mux.Vars(r)["id"]; ok {
+			owned, err := ownsInstance(r, id)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !owned {
+				respondWithError(w, http.StatusNotFound, "No record found")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ownedInstanceCredentials is instanceCredentials for instances referenced in
+// a request body, which InstanceOwnerMiddleware doesn't see
+func ownedInstanceCredentials(w http.ResponseWriter, r *http.Request, id string) (model.Credentials, bool) {
+	owned, err := ownsInstance(r, id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return model.Credentials{}, false
+	}
+	if !owned {
+		respondWithError(w, http.StatusNotFound, "No record found")
+		return model.Credentials{}, false
+	}
+	return instanceCredentials(w, id)
+}
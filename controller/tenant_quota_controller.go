@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/k8sclient"
+	"github.com/stenstromen/miniomatic/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// quotaMu serializes quota checks with the writes they guard, so concurrent
+// requests can't both pass the last free slot
+var quotaMu sync.Mutex
+
+// tenantQuota returns the limits of a tenant, falling back to the
+// QUOTA_MAX_* environment variables for tenants without stored limits
+func tenantQuota(tenant string) (model.TenantQuota, error) {
+	q, err := db.GetTenantQuota(tenant)
+	if err != nil {
+		return model.TenantQuota{}, err
+	}
+	if q != nil {
+		return *q, nil
+	}
+
+	maxInstances, _ := strconv.Atoi(os.Getenv("QUOTA_MAX_INSTANCES"))
+	return model.TenantQuota{
+		Tenant:             tenant,
+		MaxInstances:       maxInstances,
+		MaxStorage:         os.Getenv("QUOTA_MAX_STORAGE"),
+		MaxInstanceStorage: os.Getenv("QUOTA_MAX_INSTANCE_STORAGE"),
+	}, nil
+}
+
+// tenantNamespace returns the namespace a new instance of a tenant runs in,
+// with the ResourceQuota and LimitRange of the tenant
+func tenantNamespace(tenant, id string) (model.Namespace, error) {
+	q, err := tenantQuota(tenant)
+	if err != nil {
+		return model.Namespace{}, err
+	}
+	return model.Namespace{
+		Name:          k8sclient.NamespaceFor(tenant, id),
+		ResourceQuota: q.ResourceQuota,
+		LimitRange:    q.LimitRange,
+	}, nil
+}
+
+// applyTenantNamespaces updates the ResourceQuota and LimitRange of the
+// namespaces the instances of a tenant run in
+func applyTenantNamespaces(tenant string) error {
+	q, err := tenantQuota(tenant)
+	if err != nil {
+		return err
+	}
+	namespaces, err := db.GetTenantNamespaces(tenant)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		ns := model.Namespace{Name: namespace, ResourceQuota: q.ResourceQuota, LimitRange: q.LimitRange}
+		if err := k8sclient.ApplyNamespacePolicy(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tenantUsage returns the limits of a tenant with its instance count and
+// total storage
+func tenantUsage(tenant string) (model.QuotaUsage, map[string]string, error) {
+	q, err := tenantQuota(tenant)
+	if err != nil {
+		return model.QuotaUsage{}, nil, err
+	}
+	instances, err := db.GetTenantStorage(tenant)
+	if err != nil {
+		return model.QuotaUsage{}, nil, err
+	}
+	return quotaUsage(q, instances), instances, nil
+}
+
+// quotaUsage adds up the storage of the instances of a tenant, by ID
+func quotaUsage(q model.TenantQuota, instances map[string]string) model.QuotaUsage {
+	var total resource.Quantity
+	for _, storage := range instances {
+		if size, err := resource.ParseQuantity(storage); err == nil {
+			total.Add(size)
+		}
+	}
+	return model.QuotaUsage{TenantQuota: q, Instances: len(instances), Storage: total.String()}
+}
+
+// checkQuota checks whether a tenant may give an instance the requested
+// storage. id is empty for new instances. A non-zero status code is returned
+// when a limit would be exceeded. Callers hold quotaMu until the change is
+// recorded.
+func checkQuota(tenant, id string, storage resource.Quantity) (int, string) {
+	usage, instances, err := tenantUsage(tenant)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	return exceedsQuota(usage, instances, id, storage)
+}
+
+// exceedsQuota checks the requested storage of an instance against the
+// usage and limits of its tenant, see checkQuota
+func exceedsQuota(usage model.QuotaUsage, instances map[string]string, id string, storage resource.Quantity) (int, string) {
+	if usage.MaxInstanceStorage != "" {
+		if max, err := resource.ParseQuantity(usage.MaxInstanceStorage); err == nil && storage.Cmp(max) > 0 {
+			return http.StatusUnprocessableEntity, "Storage exceeds the maximum instance size of " + usage.MaxInstanceStorage
+		}
+	}
+
+	current, exists := instances[id]
+	if !exists && usage.MaxInstances > 0 && usage.Instances >= usage.MaxInstances {
+		return http.StatusForbidden, fmt.Sprintf("Instance limit reached (%d of %d)", usage.Instances, usage.MaxInstances)
+	}
+
+	if usage.MaxStorage != "" {
+		total := resource.MustParse(usage.Storage)
+		if exists {
+			if size, err := resource.ParseQuantity(current); err == nil {
+				total.Sub(size)
+			}
+		}
+		total.Add(storage)
+		if max, err := resource.ParseQuantity(usage.MaxStorage); err == nil && total.Cmp(max) > 0 {
+			return http.StatusForbidden, "Storage limit reached (" + usage.Storage + " of " + usage.MaxStorage + " in use)"
+		}
+	}
+	return 0, ""
+}
+
+// GetQuota returns the usage and limits of the caller's tenant. Global
+// callers can pick a tenant with ?tenant.
+func GetQuota(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	if tenant == "" {
+		tenant = r.URL.Query().Get("tenant")
+	}
+
+	usage, _, err := tenantUsage(tenant)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, usage)
+}
+
+// SetQuota sets the limits of a tenant. Only global callers can change limits.
+func SetQuota(w http.ResponseWriter, r *http.Request) {
+	var post model.TenantQuota
+
+	if requestTenant(r) != "" {
+		respondWithError(w, http.StatusForbidden, "Quotas can only be set by global keys")
+		return
+	}
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if post.MaxInstances < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid maxinstances. Expected a value of 0 or more")
+		return
+	}
+	for _, storage := range []string{post.MaxStorage, post.MaxInstanceStorage} {
+		if storage != "" && !validateStorageFormat(storage) {
+			respondWithError(w, http.StatusBadRequest, "Invalid storage format. Expected format: [Number][Ki|Mi|Gi]")
+			return
+		}
+	}
+
+	if msg := validateNamespacePolicy(post); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if err := db.SetTenantQuota(post); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := applyTenantNamespaces(post.Tenant); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, post)
+}
+
+// DeleteQuota reverts the limits of a tenant to the defaults
+func DeleteQuota(w http.ResponseWriter, r *http.Request) {
+	if requestTenant(r) != "" {
+		respondWithError(w, http.StatusForbidden, "Quotas can only be set by global keys")
+		return
+	}
+
+	tenant := r.URL.Query().Get("tenant")
+	if err := db.DeleteTenantQuota(tenant); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := applyTenantNamespaces(tenant); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateNamespacePolicy checks the quantities of the ResourceQuota and
+// LimitRange of a tenant, returning a message when one is invalid
+func validateNamespacePolicy(q model.TenantQuota) string {
+	lists := []map[string]string{q.ResourceQuota}
+	if q.LimitRange != nil {
+		lists = append(lists, q.LimitRange.Default, q.LimitRange.DefaultRequest, q.LimitRange.Max)
+	}
+	for _, list := range lists {
+		if _, err := k8sclient.ResourceList(list); err != nil {
+			return "Invalid namespace policy: " + err.Error()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stenstromen/miniomatic/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestQuotaUsage(t *testing.T) {
+	usage := quotaUsage(model.TenantQuota{Tenant: "acme"}, map[string]string{"a": "10Gi", "b": "512Mi", "c": "invalid"})
+	if usage.Instances != 3 || usage.Storage != "10752Mi" || usage.Tenant != "acme" {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	if usage := quotaUsage(model.TenantQuota{}, map[string]string{}); usage.Instances != 0 || usage.Storage != "0" {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestExceedsQuota(t *testing.T) {
+	instances := map[string]string{"a": "10Gi", "b": "20Gi"}
+
+	tests := []struct {
+		name    string
+		quota   model.TenantQuota
+		id      string
+		storage string
+		code    int
+	}{
+		{"unlimited", model.TenantQuota{}, "", "1000Gi", 0},
+
+		{"instance limit", model.TenantQuota{MaxInstances: 2}, "", "1Gi", http.StatusForbidden},
+		{"below instance limit", model.TenantQuota{MaxInstances: 3}, "", "1Gi", 0},
+		{"resize at instance limit", model.TenantQuota{MaxInstances: 2}, "a", "15Gi", 0},
+		{"unknown instance counts as new", model.TenantQuota{MaxInstances: 2}, "z", "1Gi", http.StatusForbidden},
+
+		{"storage limit", model.TenantQuota{MaxStorage: "40Gi"}, "", "11Gi", http.StatusForbidden},
+		{"exactly at storage limit", model.TenantQuota{MaxStorage: "40Gi"}, "", "10Gi", 0},
+		{"storage limit in other units", model.TenantQuota{MaxStorage: "30Gi"}, "", "1Mi", http.StatusForbidden},
+		{"resize counts the new size only", model.TenantQuota{MaxStorage: "40Gi"}, "a", "20Gi", 0},
+		{"resize past storage limit", model.TenantQuota{MaxStorage: "40Gi"}, "b", "31Gi", http.StatusForbidden},
+		{"invalid storage limit", model.TenantQuota{MaxStorage: "lots"}, "", "1000Gi", 0},
+
+		{"maximum instance size", model.TenantQuota{MaxInstanceStorage: "50Gi"}, "", "51Gi", http.StatusUnprocessableEntity},
+		{"exactly maximum instance size", model.TenantQuota{MaxInstanceStorage: "50Gi"}, "", "50Gi", 0},
+		{"maximum instance size in other units", model.TenantQuota{MaxInstanceStorage: "50Gi"}, "a", "51201Mi", http.StatusUnprocessableEntity},
+		{"maximum instance size before other limits", model.TenantQuota{MaxInstances: 1, MaxInstanceStorage: "50Gi"}, "", "60Gi", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := quotaUsage(tt.quota, instances)
+			if code, msg := exceedsQuota(usage, instances, tt.id, resource.MustParse(tt.storage)); code != tt.code {
+				t.Fatalf("got %d %q, want %d", code, msg, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/k8sclient"
+	"github.com/stenstromen/miniomatic/madmin"
+)
+
+func GetUsage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	creds, ok := instanceCredentials(w, id)
+	if !ok {
+		return
+	}
+
+	usage, err := madmin.DataUsage(creds)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	capacity, err := k8sclient.GetPVCCapacity(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	usage.Capacity, usage.CapacityBytes = capacity.String(), capacity.Value()
+	if usage.CapacityBytes > 0 {
+		usage.PercentUsed = math.Round(float64(usage.TotalBytes)/float64(usage.CapacityBytes)*10000) / 100
+	}
+
+	respondWithJSON(w, http.StatusOK, usage)
+}
@@ -0,0 +1,417 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stenstromen/miniomatic/auth"
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/model"
+	"github.com/stenstromen/miniomatic/rnd"
+)
+
+const (
+	// webhookInterval is how often the dispatcher looks for due deliveries
+	webhookInterval = 5 * time.Second
+	webhookTimeout  = 10 * time.Second
+	// A failed delivery is retried after webhookBackoff, doubling on every
+	// attempt, until maxWebhookAttempts have been made
+	webhookBackoff     = 30 * time.Second
+	maxWebhookAttempts = 8
+
+	// maxWebhookWorkers is the number of webhooks delivered to at once
+	maxWebhookWorkers = 16
+
+	minWebhookSecret     = 16
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+var (
+	webhooksMu sync.Mutex
+	// busyWebhooks holds the webhooks deliveries are being sent to
+	busyWebhooks = map[string]bool{}
+)
+
+// webhookClient only connects to public addresses, see webhookAddressAllowed,
+// and doesn't follow redirects, so webhooks can't reach internal services
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: webhookTimeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip) {
+					return fmt.Errorf("%w: %s", errWebhookAddress, host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConnsPerHost: 2,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+var errWebhookAddress = errors.New("address not allowed for webhooks")
+
+// reservedNetworks are blocked on top of loopback, private, link-local,
+// multicast and unspecified addresses
+var reservedNetworks = parseNetworks("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96")
+
+// webhookAddressAllowed reports whether webhooks may connect to an address.
+// Internal addresses are only allowed when they are in WEBHOOK_ALLOWED_NETWORKS.
+func webhookAddressAllowed(ip net.IP) bool {
+	for _, network := range parseNetworks(strings.Split(os.Getenv("WEBHOOK_ALLOWED_NETWORKS"), ",")...) {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(strings.TrimSpace(cidr)); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// validateWebhookURL checks that a URL is http or https and doesn't point at
+// an internal address. Addresses are checked again on every delivery, as
+// names can resolve differently later.
+func validateWebhookURL(raw string) string {
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return "Invalid url. Expected an http or https URL"
+	}
+
+	ips := []net.IP{net.ParseIP(target.Hostname())}
+	if ips[0] == nil {
+		addrs, err := net.LookupIP(target.Hostname())
+		if err != nil {
+			return "Invalid url. " + target.Hostname() + " does not resolve"
+		}
+		ips = addrs
+	}
+	for _, ip := range ips {
+		if !webhookAddressAllowed(ip) {
+			return "Invalid url. Webhooks can't be sent to loopback, private or link-local addresses"
+		}
+	}
+	return ""
+}
+
+// CreateWebhook subscribes a URL to instance events. A secret is generated
+// when none is given, it is only part of this response. Tenant admins can
+// only subscribe to the instances of their own tenant.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var post model.Webhook
+
+	if r.ContentLength == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if msg := validateWebhookURL(post.URL); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if len(post.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one event is required")
+		return
+	}
+	for _, event := range post.Events {
+		if !validWebhookEvent(event) {
+			respondWithError(w, http.StatusBadRequest, "Invalid event "+event+". Expected "+strings.Join(db.WebhookEvents, ", "))
+			return
+		}
+	}
+	if tenant := requestTenant(r); tenant != "" {
+		if post.Tenant != "" && post.Tenant != tenant {
+			respondWithError(w, http.StatusForbidden, "Webhooks can only be created for tenant "+tenant)
+			return
+		}
+		post.Tenant = tenant
+	}
+	if post.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		post.Secret = secret
+	} else if len(post.Secret) < minWebhookSecret {
+		respondWithError(w, http.StatusBadRequest, "Secret must be at least "+strconv.Itoa(minWebhookSecret)+" characters")
+		return
+	}
+
+	post.ID, post.Created = rnd.RandomString(false, 8), time.Now().Format("2006-01-02 15:04:05")
+	if err := db.InsertWebhook(post); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, post)
+}
+
+// ListWebhooks lists the webhooks of the caller's tenant, or all webhooks for global admins
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := db.GetWebhooks(requestTenant(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, hooks)
+}
+
+func GetWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, hook)
+}
+
+// DeleteWebhook unsubscribes a webhook and drops its pending deliveries
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	if err := db.DeleteWebhook(hook.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries returns the most recent deliveries of a webhook,
+// newest first, optionally filtered by status
+func ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "pending" && status != "delivered" && status != "failed" {
+		respondWithError(w, http.StatusBadRequest, "Invalid status. Expected pending, delivered or failed")
+		return
+	}
+	limit := defaultDeliveryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxDeliveryLimit {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit. Expected a value between 1 and "+strconv.Itoa(maxDeliveryLimit))
+			return
+		}
+	}
+
+	deliveries, err := db.GetWebhookDeliveries(hook.ID, status, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+// ownedWebhook returns the webhook of the request, answering 404 when it
+// doesn't exist or belongs to another tenant
+func ownedWebhook(w http.ResponseWriter, r *http.Request) (*model.Webhook, bool) {
+	hook, err := db.GetWebhook(mux.Vars(r)["webhookId"])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if hook == nil || !auth.FromContext(r.Context()).Owns(hook.Tenant) {
+		respondWithError(w, http.StatusNotFound, "No webhook found")
+		return nil, false
+	}
+	return hook, true
+}
+
+func validWebhookEvent(event string) bool {
+	for _, e := range db.WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// StartWebhookDispatcher posts queued webhook deliveries. Deliveries are kept
+// in the database, so pending ones are sent after a restart.
+func StartWebhookDispatcher() {
+	go func() {
+		ticker := time.NewTicker(webhookInterval)
+		defer ticker.Stop()
+
+		for ; true; <-ticker.C {
+			deliveries, err := db.GetDueWebhookDeliveries(time.Now())
+			if err != nil {
+				log.Printf("Error getting webhook deliveries: %v", err)
+				continue
+			}
+			dispatchWebhookDeliveries(deliveries)
+		}
+	}()
+}
+
+// dispatchWebhookDeliveries posts the deliveries of every webhook in its own
+// goroutine, so a slow endpoint only delays its own deliveries. Deliveries of
+// a webhook are sent in order: the batch stops at the first one that isn't
+// delivered, and the rest wait for its retry. Webhooks still busy with
+// earlier deliveries are skipped until they are done.
+func dispatchWebhookDeliveries(deliveries []model.WebhookDelivery) {
+	var order []string
+	batches := map[string][]model.WebhookDelivery{}
+	for _, d := range deliveries {
+		if _, ok := batches[d.Webhook]; !ok {
+			order = append(order, d.Webhook)
+		}
+		batches[d.Webhook] = append(batches[d.Webhook], d)
+	}
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	for _, hook := range order {
+		if busyWebhooks[hook] || len(busyWebhooks) >= maxWebhookWorkers {
+			continue
+		}
+		busyWebhooks[hook] = true
+
+		go func(hook string, batch []model.WebhookDelivery) {
+			defer func() {
+				webhooksMu.Lock()
+				delete(busyWebhooks, hook)
+				webhooksMu.Unlock()
+			}()
+			for _, d := range batch {
+				if !deliverWebhook(d) {
+					return
+				}
+			}
+		}(hook, batches[hook])
+	}
+}
+
+// deliverWebhook makes one attempt at a delivery and schedules the next one
+// with exponential backoff when it fails. It returns whether the delivery
+// was delivered.
+func deliverWebhook(d model.WebhookDelivery) bool {
+	if claimed, err := db.ClaimWebhookDelivery(d); err != nil || !claimed {
+		if err != nil {
+			log.Printf("Error claiming webhook delivery %d: %v", d.ID, err)
+		}
+		return false
+	}
+	d.Attempts++
+	code, err := postWebhook(d)
+	d.ResponseCode = code
+
+	var next time.Time
+	switch {
+	case err == nil:
+		d.Status, d.Error = "delivered", ""
+	case d.Attempts >= maxWebhookAttempts:
+		d.Status, d.Error = "failed", err.Error()
+		log.Printf("Giving up on webhook delivery %d to %s: %v", d.ID, d.URL, err)
+	default:
+		d.Error = err.Error()
+		next = time.Now().Add(webhookBackoff << (d.Attempts - 1))
+	}
+
+	if err := db.UpdateWebhookDelivery(d, next); err != nil {
+		log.Printf("Error updating webhook delivery %d: %v", d.ID, err)
+	}
+	return d.Status == "delivered"
+}
+
+// postWebhook posts the payload of a delivery, signed with the webhook
+// secret, and returns the response status code. Redirects count as failures.
+func postWebhook(d model.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "miniomatic")
+	req.Header.Set("X-Miniomatic-Event", d.Event)
+	req.Header.Set("X-Miniomatic-Delivery", strconv.FormatInt(d.ID, 10))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Miniomatic-Timestamp", timestamp)
+	req.Header.Set("X-Miniomatic-Signature", "sha256="+signPayload(d.Secret, timestamp, d.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of the timestamp, a dot
+// and the payload. Signing the timestamp lets receivers reject replayed
+// deliveries.
+func signPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
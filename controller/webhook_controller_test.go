@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+func TestSignPayload(t *testing.T) {
+	// echo -n '1700000000.{"event":"ready"}' | openssl dgst -sha256 -hmac whsec_test
+	const want = "322b68ba7f6838b97e5a6ebfc58f84865210405271bb7e5d9a9fedaa0f90aad3"
+	if got := signPayload("whsec_test", "1700000000", []byte(`{"event":"ready"}`)); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	// The timestamp is part of the signature, so it can't be swapped
+	if signPayload("whsec_test", "1700000001", []byte(`{"event":"ready"}`)) == want {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+}
+
+func TestWebhookAddressAllowed(t *testing.T) {
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := webhookAddressAllowed(net.ParseIP(tt.ip)); got != tt.allowed {
+				t.Fatalf("got %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+
+	t.Run("allowed networks", func(t *testing.T) {
+		t.Setenv("WEBHOOK_ALLOWED_NETWORKS", "10.20.0.0/16, invalid")
+		if !webhookAddressAllowed(net.ParseIP("10.20.1.1")) {
+			t.Fatal("expected address in WEBHOOK_ALLOWED_NETWORKS to be allowed")
+		}
+		if webhookAddressAllowed(net.ParseIP("10.21.1.1")) {
+			t.Fatal("expected address outside WEBHOOK_ALLOWED_NETWORKS to be blocked")
+		}
+	})
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://8.8.8.8/hooks", true},
+		{"ftp://8.8.8.8/hooks", false},
+		{"https:///hooks", false},
+		{"not a url", false},
+		{"http://127.0.0.1:8080/hooks", false},
+		{"http://[::1]/hooks", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://localhost/hooks", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if msg := validateWebhookURL(tt.url); (msg == "") != tt.ok {
+				t.Fatalf("expected ok %v, got %q", tt.ok, msg)
+			}
+		})
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	var received *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	delivery := model.WebhookDelivery{
+		ID:      7,
+		Event:   "ready",
+		Payload: []byte(`{"event":"ready","instance":"abc123"}`),
+		URL:     server.URL,
+		Secret:  "whsec_test",
+	}
+
+	if _, err := postWebhook(delivery); !errors.Is(err, errWebhookAddress) {
+		t.Fatalf("expected loopback delivery to be blocked, got %v", err)
+	}
+	if received != nil {
+		t.Fatal("blocked delivery reached the server")
+	}
+
+	t.Setenv("WEBHOOK_ALLOWED_NETWORKS", "127.0.0.0/8")
+	code, err := postWebhook(delivery)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("expected delivery, got %d %v", code, err)
+	}
+	if received.Header.Get("X-Miniomatic-Event") != "ready" || received.Header.Get("X-Miniomatic-Delivery") != "7" {
+		t.Fatalf("unexpected headers %v", received.Header)
+	}
+	timestamp := received.Header.Get("X-Miniomatic-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Fatalf("unexpected timestamp %q", timestamp)
+	}
+	if want := "sha256=" + signPayload("whsec_test", timestamp, []byte(body)); received.Header.Get("X-Miniomatic-Signature") != want {
+		t.Fatalf("signature %s does not match %s", received.Header.Get("X-Miniomatic-Signature"), want)
+	}
+}
+
+func TestPostWebhookRedirect(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOWED_NETWORKS", "127.0.0.0/8")
+
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	code, err := postWebhook(model.WebhookDelivery{Event: "ready", Payload: []byte(`{}`), URL: redirect.URL, Secret: "whsec_test"})
+	if err == nil || code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected redirect to fail the delivery, got %d %v", code, err)
+	}
+	if followed {
+		t.Fatal("redirect was followed")
+	}
+}
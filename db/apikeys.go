@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const apiKeysTable = `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		tenant TEXT NOT NULL DEFAULT '',
+		hash TEXT NOT NULL UNIQUE,
+		operations TEXT NOT NULL,
+		expires TEXT NOT NULL DEFAULT '',
+		created TEXT NOT NULL
+	);
+	`
+
+const apiKeyColumns = "id, name, tenant, role, operations, expires, created"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (model.APIKey, error) {
+	var k model.APIKey
+	var operations string
+	if err := row.Scan(&k.ID, &k.Name, &k.Tenant, &k.Role, &operations, &k.Expires, &k.Created); err != nil {
+		return k, err
+	}
+	if operations != "" {
+		k.Operations = strings.Split(operations, ",")
+	}
+	return k, nil
+}
+
+// InsertAPIKey stores an API key under the hash of its secret
+func InsertAPIKey(k model.APIKey, hash string) error {
+	_, err := db.Exec("INSERT INTO api_keys (id, name, tenant, role, hash, operations, expires, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		k.ID, k.Name, k.Tenant, k.Role, hash, strings.Join(k.Operations, ","), k.Expires, k.Created)
+	return err
+}
+
+// GetAPIKeyByHash returns the API key stored under a hash, nil if there is none
+func GetAPIKeyByHash(hash string) (*model.APIKey, error) {
+	k, err := scanAPIKey(db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE hash = ?", hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &k, nil
+}
+
+// GetAPIKey returns an API key by its ID, nil if it doesn't exist
+func GetAPIKey(id string) (*model.APIKey, error) {
+	k, err := scanAPIKey(db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &k, nil
+}
+
+// GetAPIKeys returns the API keys of a tenant, or of every tenant when tenant is empty
+func GetAPIKeys(tenant string) ([]model.APIKey, error) {
+	query, args := "SELECT "+apiKeyColumns+" FROM api_keys", []interface{}{}
+	if tenant != "" {
+		query, args = query+" WHERE tenant = ?", append(args, tenant)
+	}
+	rows, err := db.Query(query+" ORDER BY created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes an API key
+func DeleteAPIKey(id string) error {
+	_, err := db.Exec("DELETE FROM api_keys WHERE id = ?", id)
+	return err
+}
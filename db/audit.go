@@ -0,0 +1,96 @@
+package db
+
+import (
+	"time"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// The audit log is append-only, the triggers reject any change to past entries
+const auditLogTable = `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		caller TEXT NOT NULL,
+		caller_name TEXT NOT NULL DEFAULT '',
+		tenant TEXT NOT NULL DEFAULT '',
+		method TEXT NOT NULL,
+		path TEXT NOT NULL,
+		action TEXT NOT NULL,
+		instance_id TEXT NOT NULL DEFAULT '',
+		payload TEXT NOT NULL DEFAULT '',
+		status INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS audit_log_instance_id ON audit_log (instance_id, seq);
+	CREATE INDEX IF NOT EXISTS audit_log_caller ON audit_log (caller, seq);
+	CREATE INDEX IF NOT EXISTS audit_log_date ON audit_log (date);
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+	`
+
+// AuditFilter selects audit entries. Empty fields match everything, From and
+// To are inclusive dates in the database format.
+type AuditFilter struct {
+	Tenant   string
+	Instance string
+	Caller   string
+	From     string
+	To       string
+	Limit    int
+}
+
+// InsertAuditEntry appends an entry to the audit log
+func InsertAuditEntry(e model.AuditEntry) error {
+	_, err := db.Exec(`INSERT INTO audit_log (date, caller, caller_name, tenant, method, path, action, instance_id, payload, status, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().Format("2006-01-02 15:04:05"), e.Caller, e.CallerName, e.Tenant, e.Method, e.Path, e.Action, e.InstanceID, e.Payload, e.Status, e.Error)
+	return err
+}
+
+// QueryAuditLog calls fn for every entry matching filter, newest first,
+// stopping at the first error
+func QueryAuditLog(filter AuditFilter, fn func(model.AuditEntry) error) error {
+	query := "SELECT seq, date, caller, caller_name, tenant, method, path, action, instance_id, payload, status, error FROM audit_log WHERE 1 = 1"
+	var args []interface{}
+	for _, condition := range []struct {
+		clause, value string
+	}{
+		{" AND tenant = ?", filter.Tenant},
+		{" AND instance_id = ?", filter.Instance},
+		{" AND caller = ?", filter.Caller},
+		{" AND date >= ?", filter.From},
+		{" AND date <= ?", filter.To},
+	} {
+		if condition.value != "" {
+			query, args = query+condition.clause, append(args, condition.value)
+		}
+	}
+	query += " ORDER BY seq DESC"
+	if filter.Limit > 0 {
+		query, args = query+" LIMIT ?", append(args, filter.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e model.AuditEntry
+		if err := rows.Scan(&e.Seq, &e.Date, &e.Caller, &e.CallerName, &e.Tenant, &e.Method, &e.Path, &e.Action, &e.InstanceID, &e.Payload, &e.Status, &e.Error); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const autoscaleTable = `
+	CREATE TABLE IF NOT EXISTS autoscale_policies (
+		instance_id TEXT PRIMARY KEY,
+		threshold_percent INTEGER NOT NULL,
+		step TEXT NOT NULL,
+		max_storage TEXT NOT NULL
+	);
+	`
+
+// SetAutoscalePolicy creates or replaces the autoscaling policy of an instance
+func SetAutoscalePolicy(id string, policy model.AutoscalePolicy) error {
+	_, err := db.Exec(`INSERT INTO autoscale_policies (instance_id, threshold_percent, step, max_storage) VALUES (?, ?, ?, ?)
+		ON CONFLICT (instance_id) DO UPDATE SET threshold_percent = excluded.threshold_percent, step = excluded.step, max_storage = excluded.max_storage`,
+		id, policy.ThresholdPercent, policy.Step, policy.MaxStorage)
+	return err
+}
+
+// GetAutoscalePolicy returns the autoscaling policy of an instance, nil if it has none
+func GetAutoscalePolicy(id string) (*model.AutoscalePolicy, error) {
+	row := db.QueryRow("SELECT instance_id, threshold_percent, step, max_storage FROM autoscale_policies WHERE instance_id = ?", id)
+
+	var p model.AutoscalePolicy
+	if err := row.Scan(&p.ID, &p.ThresholdPercent, &p.Step, &p.MaxStorage); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetAutoscalePolicies returns the autoscaling policies of all instances
+func GetAutoscalePolicies() ([]model.AutoscalePolicy, error) {
+	rows, err := db.Query("SELECT instance_id, threshold_percent, step, max_storage FROM autoscale_policies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []model.AutoscalePolicy
+	for rows.Next() {
+		var p model.AutoscalePolicy
+		if err := rows.Scan(&p.ID, &p.ThresholdPercent, &p.Step, &p.MaxStorage); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// DeleteAutoscalePolicy removes the autoscaling policy of an instance
+func DeleteAutoscalePolicy(id string) error {
+	_, err := db.Exec("DELETE FROM autoscale_policies WHERE instance_id = ?", id)
+	return err
+}
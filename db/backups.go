@@ -0,0 +1,105 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const backupJobsTable = `
+	CREATE TABLE IF NOT EXISTS backup_jobs (
+		id TEXT PRIMARY KEY,
+		instance_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		backup_id TEXT NOT NULL DEFAULT '',
+		prefix TEXT NOT NULL,
+		status TEXT NOT NULL,
+		date TEXT NOT NULL,
+		finished TEXT NOT NULL DEFAULT '',
+		objects INTEGER NOT NULL DEFAULT 0,
+		bytes INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS backup_jobs_instance_id ON backup_jobs (instance_id, type, date);
+	`
+
+const backupJobColumns = "id, instance_id, type, backup_id, prefix, status, date, finished, objects, bytes, error, schedule_id, tenant"
+
+func scanBackupJob(row interface{ Scan(...interface{}) error }) (model.BackupJob, error) {
+	var j model.BackupJob
+	err := row.Scan(&j.ID, &j.InstanceID, &j.Type, &j.BackupID, &j.Prefix, &j.Status, &j.Date, &j.Finished, &j.Objects, &j.Bytes, &j.Error, &j.ScheduleID, &j.Tenant)
+	return j, err
+}
+
+// InsertBackupJob records a new backup or restore job along with the tenant
+// of its instance
+func InsertBackupJob(j model.BackupJob) error {
+	_, err := db.Exec("INSERT INTO backup_jobs ("+backupJobColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, "+instanceTenant+")",
+		j.ID, j.InstanceID, j.Type, j.BackupID, j.Prefix, j.Status, j.Date, j.Finished, j.Objects, j.Bytes, j.Error, j.ScheduleID, j.InstanceID)
+	return err
+}
+
+// UpdateBackupJobProgress stores the number of objects and bytes copied so far
+func UpdateBackupJobProgress(id string, objects, bytes int64) error {
+	_, err := db.Exec("UPDATE backup_jobs SET objects = ?, bytes = ? WHERE id = ?", objects, bytes, id)
+	return err
+}
+
+// FinishBackupJob sets the final status of a job and its error, if any
+func FinishBackupJob(id, status, jobErr string) error {
+	_, err := db.Exec("UPDATE backup_jobs SET status = ?, error = ?, finished = ? WHERE id = ?", status, jobErr, time.Now().Format("2006-01-02 15:04:05"), id)
+	return err
+}
+
+// FailRunningBackupJobs marks jobs that were running when the server stopped as failed
+func FailRunningBackupJobs() error {
+	_, err := db.Exec("UPDATE backup_jobs SET status = 'failed', error = 'interrupted by server restart', finished = ? WHERE status = 'running'", time.Now().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// GetBackupJob returns a job by its ID, nil if it doesn't exist
+func GetBackupJob(id string) (*model.BackupJob, error) {
+	j, err := scanBackupJob(db.QueryRow("SELECT "+backupJobColumns+" FROM backup_jobs WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &j, nil
+}
+
+// GetBackupJobs returns the jobs of a type for an instance, newest first
+func GetBackupJobs(instanceID, jobType string) ([]model.BackupJob, error) {
+	return queryBackupJobs("SELECT "+backupJobColumns+" FROM backup_jobs WHERE instance_id = ? AND type = ? ORDER BY date DESC", instanceID, jobType)
+}
+
+// GetScheduleBackups returns the backups taken by a backup schedule, newest first
+func GetScheduleBackups(scheduleID string) ([]model.BackupJob, error) {
+	return queryBackupJobs("SELECT "+backupJobColumns+" FROM backup_jobs WHERE schedule_id = ? AND type = 'backup' ORDER BY date DESC", scheduleID)
+}
+
+func queryBackupJobs(query string, args ...interface{}) ([]model.BackupJob, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []model.BackupJob{}
+	for rows.Next() {
+		j, err := scanBackupJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// DeleteBackupJob removes a job record
+func DeleteBackupJob(id string) error {
+	_, err := db.Exec("DELETE FROM backup_jobs WHERE id = ?", id)
+	return err
+}
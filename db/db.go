@@ -39,22 +39,124 @@ func InitDB() error {
 		log.Fatalf("failed to create table: %v", err)
 	}
 
+	if err := addColumnIfMissing("records", "quota_percent", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		log.Fatalf("failed to migrate table: %v", err)
+	}
+	if err := addColumnIfMissing("records", "storage_class", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		log.Fatalf("failed to migrate table: %v", err)
+	}
+	if err := addColumnIfMissing("records", "tenant", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		log.Fatalf("failed to migrate table: %v", err)
+	}
+	if err := addColumnIfMissing("records", "namespace", "TEXT NOT NULL DEFAULT 'miniomatic'"); err != nil {
+		log.Fatalf("failed to migrate table: %v", err)
+	}
+
+	for _, query := range []string{eventsTable, autoscaleTable, snapshotsTable, backupJobsTable, backupSchedulesTable, apiKeysTable, tenantQuotasTable, auditLogTable, webhooksTable} {
+		if _, err := db.Exec(query); err != nil {
+			log.Fatalf("failed to create table: %v", err)
+		}
+	}
+
+	for _, table := range []string{"snapshots", "backup_jobs"} {
+		if err := addColumnIfMissing(table, "schedule_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			log.Fatalf("failed to migrate table: %v", err)
+		}
+	}
+	if err := addColumnIfMissing("api_keys", "role", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		log.Fatalf("failed to migrate table: %v", err)
+	}
+	if err := addColumnIfMissing("snapshots", "namespace", "TEXT NOT NULL DEFAULT 'miniomatic'"); err != nil {
+		log.Fatalf("failed to migrate table: %v", err)
+	}
+	// Snapshots and backups keep the tenant of their instance, so tenants
+	// can still reach them once the instance is deleted
+	for _, table := range []string{"snapshots", "backup_jobs"} {
+		if err := addColumnIfMissing(table, "tenant", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			log.Fatalf("failed to migrate table: %v", err)
+		}
+		if _, err := db.Exec("UPDATE " + table + " SET tenant = (SELECT tenant FROM records WHERE records.id = " + table + ".instance_id) WHERE tenant = '' AND instance_id IN (SELECT id FROM records)"); err != nil {
+			log.Fatalf("failed to migrate table: %v", err)
+		}
+	}
+	if err := addColumnIfMissing("snapshots", "root_user", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		log.Fatalf("failed to migrate table: %v", err)
+	}
+	for _, column := range []string{"resource_quota", "limit_range"} {
+		if err := addColumnIfMissing("tenant_quotas", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			log.Fatalf("failed to migrate table: %v", err)
+		}
+	}
+
+	if err := FailRunningBackupJobs(); err != nil {
+		log.Fatalf("failed to update backup jobs: %v", err)
+	}
+
 	return nil
 }
 
+// addColumnIfMissing adds a column to an existing table, so databases created
+// by older versions pick up new columns
+func addColumnIfMissing(table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// UpdateStatus sets the status of an instance and records the transition in
+// its history
 func UpdateStatus(id, status string) error {
+	var previous string
+	if err := db.QueryRow("SELECT status FROM records WHERE id = ?", id).Scan(&previous); err != nil && err != sql.ErrNoRows {
+		log.Fatalf("failed to get status: %v", err)
+	}
+
 	_, err := db.Exec("UPDATE records SET status = ? WHERE id = ?", status, id)
 	if err != nil {
 		log.Fatalf("failed to update status: %v", err)
 	}
+
+	if previous == "" || previous == status {
+		return nil
+	}
+	// The status has changed either way, failing to record it is only logged
+	message := "Status changed from " + previous + " to " + status
+	if err := InsertEvent(id, EventStatus, message); err != nil {
+		log.Printf("failed to record status event: %v", err)
+	}
+	// ready is only sent once a new instance is set up, returning to ready
+	// after a resize or migration isn't news to webhooks
+	if status == "ready" && (previous == "provisioning" || previous == "importing") {
+		if err := queueWebhookDeliveries(id, WebhookReady, message); err != nil {
+			log.Printf("failed to queue webhook deliveries: %v", err)
+		}
+	}
 	return nil
 }
 
 // InsertData inserts a new record into the database
-func InsertData(id, initBucket, storage string) error {
+func InsertData(id, initBucket, storage, storageClass, tenant, namespace string, quotaPercent int) error {
 	currentTime, url := time.Now().Format("2006-01-02 15:04:05"), "https://"+id+"."+os.Getenv("WILDCARD_DOMAIN")
 
-	_, err := db.Exec("INSERT INTO records (date, id, init_bucket, url, storage) VALUES (?, ?, ?, ?, ?)", currentTime, id, initBucket, url, storage)
+	_, err := db.Exec("INSERT INTO records (date, id, init_bucket, url, storage, storage_class, tenant, namespace, quota_percent) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", currentTime, id, initBucket, url, storage, storageClass, tenant, namespace, quotaPercent)
 	if err != nil {
 		log.Fatalf("failed to insert data: %v", err)
 	}
@@ -69,6 +171,12 @@ func UpdateData(id, initBucket, storage string) error {
 	return nil
 }
 
+// UpdateStorageClass records the storage class an instance was migrated to
+func UpdateStorageClass(id, storageClass string) error {
+	_, err := db.Exec("UPDATE records SET storage_class = ? WHERE id = ?", storageClass, id)
+	return err
+}
+
 // DeleteData deletes a record by its ID
 func DeleteData(id string) error {
 	result, err := db.Exec("DELETE FROM records WHERE id = ?", id)
@@ -89,7 +197,7 @@ func DeleteData(id string) error {
 }
 
 func GetAllData() ([]model.Record, error) {
-	rows, err := db.Query("SELECT status, date, id, init_bucket, url, storage FROM records")
+	rows, err := db.Query("SELECT status, date, id, init_bucket, url, storage, storage_class, tenant, namespace, quota_percent FROM records")
 	if err != nil {
 		log.Fatalf("failed to get all data: %v", err)
 	}
@@ -98,7 +206,7 @@ func GetAllData() ([]model.Record, error) {
 	var records []model.Record
 	for rows.Next() {
 		var r model.Record
-		if err := rows.Scan(&r.Status, &r.Date, &r.ID, &r.InitBucket, &r.URL, &r.Storage); err != nil {
+		if err := rows.Scan(&r.Status, &r.Date, &r.ID, &r.InitBucket, &r.URL, &r.Storage, &r.StorageClass, &r.Tenant, &r.Namespace, &r.QuotaPercent); err != nil {
 			return nil, err
 		}
 		records = append(records, r)
@@ -114,10 +222,10 @@ func GetAllData() ([]model.Record, error) {
 
 // GetDataByID retrieves a specific record by its ID
 func GetDataByID(id string) (*model.Record, error) {
-	row := db.QueryRow("SELECT status, date, id, init_bucket, url, storage FROM records WHERE id = ?", id)
+	row := db.QueryRow("SELECT status, date, id, init_bucket, url, storage, storage_class, tenant, namespace, quota_percent FROM records WHERE id = ?", id)
 
 	var r model.Record
-	if err := row.Scan(&r.Status, &r.Date, &r.ID, &r.InitBucket, &r.URL, &r.Storage); err != nil {
+	if err := row.Scan(&r.Status, &r.Date, &r.ID, &r.InitBucket, &r.URL, &r.Storage, &r.StorageClass, &r.Tenant, &r.Namespace, &r.QuotaPercent); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No data found for the given ID
 		}
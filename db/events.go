@@ -0,0 +1,82 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const eventsTable = `
+	CREATE TABLE IF NOT EXISTS events (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		instance_id TEXT NOT NULL,
+		date TEXT NOT NULL,
+		type TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS events_instance_id ON events (instance_id, seq);
+	`
+
+// Event types recorded in the history of an instance
+const (
+	EventCreated     = "created"
+	EventStatus      = "status"
+	EventResized     = "resized"
+	EventAutoscaled  = "autoscaled"
+	EventMigrated    = "migrated"
+	EventCredentials = "credentials"
+	EventFailed      = "failed"
+	EventDeleted     = "deleted"
+)
+
+// InsertEvent appends an entry to the history of an instance and queues it
+// for the webhooks subscribed to it
+func InsertEvent(id, eventType, message string) error {
+	_, err := db.Exec("INSERT INTO events (instance_id, date, type, message) VALUES (?, ?, ?, ?)", id, time.Now().Format("2006-01-02 15:04:05"), eventType, message)
+	if err != nil {
+		return err
+	}
+	if event, ok := webhookEvents[eventType]; ok {
+		return queueWebhookDeliveries(id, event, message)
+	}
+	return nil
+}
+
+// GetEvents returns the history of an instance in chronological order,
+// optionally limited to the given event types
+func GetEvents(id string, eventTypes ...string) ([]model.Event, error) {
+	query, args := "SELECT date, type, message FROM events WHERE instance_id = ?", []interface{}{id}
+	if len(eventTypes) > 0 {
+		query += " AND type IN (?" + strings.Repeat(", ?", len(eventTypes)-1) + ")"
+		for _, eventType := range eventTypes {
+			args = append(args, eventType)
+		}
+	}
+	query += " ORDER BY seq"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []model.Event{}
+	for rows.Next() {
+		var e model.Event
+		if err := rows.Scan(&e.Date, &e.Type, &e.Message); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// FailInstance marks an instance as failed and records the error that
+// caused it
+func FailInstance(id string, cause error) error {
+	if err := UpdateStatus(id, "failed"); err != nil {
+		return err
+	}
+	return InsertEvent(id, EventFailed, cause.Error())
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const backupSchedulesTable = `
+	CREATE TABLE IF NOT EXISTS backup_schedules (
+		id TEXT PRIMARY KEY,
+		instance_id TEXT NOT NULL,
+		schedule TEXT NOT NULL,
+		type TEXT NOT NULL,
+		keep_daily INTEGER NOT NULL DEFAULT 0,
+		keep_weekly INTEGER NOT NULL DEFAULT 0,
+		next_run TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS backup_schedules_instance_id ON backup_schedules (instance_id);
+	CREATE TABLE IF NOT EXISTS schedule_runs (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		schedule_id TEXT NOT NULL,
+		date TEXT NOT NULL,
+		artifact TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS schedule_runs_schedule_id ON schedule_runs (schedule_id, seq);
+	`
+
+const backupScheduleColumns = "id, instance_id, schedule, type, keep_daily, keep_weekly, next_run"
+
+// scheduleRunHistory is the number of runs returned with a schedule
+const scheduleRunHistory = 50
+
+func queryBackupSchedules(query string, args ...interface{}) ([]model.BackupSchedule, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []model.BackupSchedule{}
+	for rows.Next() {
+		var s model.BackupSchedule
+		if err := rows.Scan(&s.ID, &s.InstanceID, &s.Schedule, &s.Type, &s.KeepDaily, &s.KeepWeekly, &s.NextRun); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// SetBackupSchedule creates or replaces a backup schedule
+func SetBackupSchedule(s model.BackupSchedule) error {
+	_, err := db.Exec(`INSERT INTO backup_schedules (`+backupScheduleColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET schedule = excluded.schedule, type = excluded.type, keep_daily = excluded.keep_daily, keep_weekly = excluded.keep_weekly, next_run = excluded.next_run`,
+		s.ID, s.InstanceID, s.Schedule, s.Type, s.KeepDaily, s.KeepWeekly, s.NextRun)
+	return err
+}
+
+// GetBackupSchedule returns a backup schedule by its ID, nil if it doesn't exist
+func GetBackupSchedule(id string) (*model.BackupSchedule, error) {
+	row := db.QueryRow("SELECT "+backupScheduleColumns+" FROM backup_schedules WHERE id = ?", id)
+
+	var s model.BackupSchedule
+	if err := row.Scan(&s.ID, &s.InstanceID, &s.Schedule, &s.Type, &s.KeepDaily, &s.KeepWeekly, &s.NextRun); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetBackupSchedules returns the backup schedules of an instance
+func GetBackupSchedules(instanceID string) ([]model.BackupSchedule, error) {
+	return queryBackupSchedules("SELECT "+backupScheduleColumns+" FROM backup_schedules WHERE instance_id = ?", instanceID)
+}
+
+// GetDueBackupSchedules returns the backup schedules whose next run is at or before now
+func GetDueBackupSchedules(now time.Time) ([]model.BackupSchedule, error) {
+	return queryBackupSchedules("SELECT "+backupScheduleColumns+" FROM backup_schedules WHERE next_run <= ?", now.Format("2006-01-02 15:04:05"))
+}
+
+// UpdateBackupScheduleNextRun sets when a backup schedule runs next
+func UpdateBackupScheduleNextRun(id string, next time.Time) error {
+	_, err := db.Exec("UPDATE backup_schedules SET next_run = ? WHERE id = ?", next.Format("2006-01-02 15:04:05"), id)
+	return err
+}
+
+// DeleteBackupSchedule removes a backup schedule and its run history
+func DeleteBackupSchedule(id string) error {
+	if _, err := db.Exec("DELETE FROM schedule_runs WHERE schedule_id = ?", id); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM backup_schedules WHERE id = ?", id)
+	return err
+}
+
+// InsertScheduleRun records a run of a backup schedule
+func InsertScheduleRun(scheduleID, artifact, runErr string) error {
+	_, err := db.Exec("INSERT INTO schedule_runs (schedule_id, date, artifact, error) VALUES (?, ?, ?, ?)", scheduleID, time.Now().Format("2006-01-02 15:04:05"), artifact, runErr)
+	return err
+}
+
+// GetScheduleRuns returns the most recent runs of a backup schedule, newest first
+func GetScheduleRuns(scheduleID string) ([]model.ScheduleRun, error) {
+	rows, err := db.Query("SELECT date, artifact, error FROM schedule_runs WHERE schedule_id = ? ORDER BY seq DESC LIMIT ?", scheduleID, scheduleRunHistory)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []model.ScheduleRun
+	for rows.Next() {
+		var r model.ScheduleRun
+		if err := rows.Scan(&r.Date, &r.Artifact, &r.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
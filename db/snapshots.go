@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const snapshotsTable = `
+	CREATE TABLE IF NOT EXISTS snapshots (
+		id TEXT PRIMARY KEY,
+		instance_id TEXT NOT NULL,
+		date TEXT NOT NULL,
+		snapshot_class TEXT NOT NULL DEFAULT '',
+		storage TEXT NOT NULL,
+		retain INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS snapshots_instance_id ON snapshots (instance_id);
+	`
+
+const snapshotColumns = "id, instance_id, date, snapshot_class, storage, retain, schedule_id, namespace, tenant, root_user"
+
+// InsertSnapshot records a new VolumeSnapshot of an instance along with the
+// tenant of the instance
+func InsertSnapshot(s model.Snapshot) error {
+	_, err := db.Exec("INSERT INTO snapshots ("+snapshotColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, "+instanceTenant+", ?)",
+		s.ID, s.InstanceID, time.Now().Format("2006-01-02 15:04:05"), s.SnapshotClass, s.Storage, s.Retain, s.ScheduleID, s.Namespace, s.InstanceID, s.RootUser)
+	return err
+}
+
+// GetSnapshot returns a snapshot by its ID, nil if it doesn't exist
+func GetSnapshot(id string) (*model.Snapshot, error) {
+	row := db.QueryRow("SELECT "+snapshotColumns+" FROM snapshots WHERE id = ?", id)
+
+	var s model.Snapshot
+	if err := row.Scan(&s.ID, &s.InstanceID, &s.Date, &s.SnapshotClass, &s.Storage, &s.Retain, &s.ScheduleID, &s.Namespace, &s.Tenant, &s.RootUser); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSnapshots returns the snapshots of an instance, oldest first
+func GetSnapshots(instanceID string) ([]model.Snapshot, error) {
+	rows, err := db.Query("SELECT "+snapshotColumns+" FROM snapshots WHERE instance_id = ? ORDER BY date", instanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []model.Snapshot{}
+	for rows.Next() {
+		var s model.Snapshot
+		if err := rows.Scan(&s.ID, &s.InstanceID, &s.Date, &s.SnapshotClass, &s.Storage, &s.Retain, &s.ScheduleID, &s.Namespace, &s.Tenant, &s.RootUser); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+// GetScheduleSnapshots returns the snapshots taken by a backup schedule, newest first
+func GetScheduleSnapshots(scheduleID string) ([]model.Snapshot, error) {
+	rows, err := db.Query("SELECT "+snapshotColumns+" FROM snapshots WHERE schedule_id = ? ORDER BY date DESC", scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []model.Snapshot{}
+	for rows.Next() {
+		var s model.Snapshot
+		if err := rows.Scan(&s.ID, &s.InstanceID, &s.Date, &s.SnapshotClass, &s.Storage, &s.Retain, &s.ScheduleID, &s.Namespace, &s.Tenant, &s.RootUser); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+// DeleteSnapshot removes a snapshot record
+func DeleteSnapshot(id string) error {
+	_, err := db.Exec("DELETE FROM snapshots WHERE id = ?", id)
+	return err
+}
+
+// NamespaceInUse reports whether an instance or snapshot still lives in a namespace
+func NamespaceInUse(namespace string) (bool, error) {
+	var inUse bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM records WHERE namespace = ?) OR EXISTS (SELECT 1 FROM snapshots WHERE namespace = ?)", namespace, namespace).Scan(&inUse)
+	return inUse, err
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const tenantQuotasTable = `
+	CREATE TABLE IF NOT EXISTS tenant_quotas (
+		tenant TEXT PRIMARY KEY,
+		max_instances INTEGER NOT NULL DEFAULT 0,
+		max_storage TEXT NOT NULL DEFAULT '',
+		max_instance_storage TEXT NOT NULL DEFAULT ''
+	);
+	`
+
+// SetTenantQuota stores the limits of a tenant, replacing previous limits
+func SetTenantQuota(q model.TenantQuota) error {
+	resourceQuota, limitRange, err := encodeNamespacePolicy(q)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO tenant_quotas (tenant, max_instances, max_storage, max_instance_storage, resource_quota, limit_range) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(tenant) DO UPDATE SET max_instances = excluded.max_instances, max_storage = excluded.max_storage, max_instance_storage = excluded.max_instance_storage,
+		resource_quota = excluded.resource_quota, limit_range = excluded.limit_range`,
+		q.Tenant, q.MaxInstances, q.MaxStorage, q.MaxInstanceStorage, resourceQuota, limitRange)
+	return err
+}
+
+// GetTenantQuota returns the limits stored for a tenant, nil if there are none
+func GetTenantQuota(tenant string) (*model.TenantQuota, error) {
+	q := model.TenantQuota{Tenant: tenant}
+	var resourceQuota, limitRange string
+	err := db.QueryRow("SELECT max_instances, max_storage, max_instance_storage, resource_quota, limit_range FROM tenant_quotas WHERE tenant = ?", tenant).
+		Scan(&q.MaxInstances, &q.MaxStorage, &q.MaxInstanceStorage, &resourceQuota, &limitRange)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// The namespace policy is stored as JSON, empty when it isn't set
+	if resourceQuota != "" {
+		if err := json.Unmarshal([]byte(resourceQuota), &q.ResourceQuota); err != nil {
+			return nil, err
+		}
+	}
+	if limitRange != "" {
+		if err := json.Unmarshal([]byte(limitRange), &q.LimitRange); err != nil {
+			return nil, err
+		}
+	}
+	return &q, nil
+}
+
+func encodeNamespacePolicy(q model.TenantQuota) (string, string, error) {
+	var resourceQuota, limitRange []byte
+	var err error
+	if len(q.ResourceQuota) > 0 {
+		if resourceQuota, err = json.Marshal(q.ResourceQuota); err != nil {
+			return "", "", err
+		}
+	}
+	if q.LimitRange != nil {
+		if limitRange, err = json.Marshal(q.LimitRange); err != nil {
+			return "", "", err
+		}
+	}
+	return string(resourceQuota), string(limitRange), nil
+}
+
+// GetTenantNamespaces returns the namespaces the instances of a tenant run in
+func GetTenantNamespaces(tenant string) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT namespace FROM records WHERE tenant = ?", tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var namespaces []string
+	for rows.Next() {
+		var namespace string
+		if err := rows.Scan(&namespace); err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, rows.Err()
+}
+
+// DeleteTenantQuota removes the limits stored for a tenant
+func DeleteTenantQuota(tenant string) error {
+	_, err := db.Exec("DELETE FROM tenant_quotas WHERE tenant = ?", tenant)
+	return err
+}
+
+// GetTenantStorage returns the ID and storage of every instance of a tenant
+func GetTenantStorage(tenant string) (map[string]string, error) {
+	rows, err := db.Query("SELECT id, storage FROM records WHERE tenant = ?", tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	storage := make(map[string]string)
+	for rows.Next() {
+		var id, size string
+		if err := rows.Scan(&id, &size); err != nil {
+			return nil, err
+		}
+		storage[id] = size
+	}
+	return storage, rows.Err()
+}
+
+// instanceTenant is an SQL expression for the tenant of the instance whose
+// ID is bound to it, empty for global instances
+const instanceTenant = "COALESCE((SELECT tenant FROM records WHERE id = ?), '')"
+
+// InstanceTenant returns the tenant of an instance. Deleted instances have
+// the tenant recorded on their snapshots and backups. found is false when
+// nothing of the instance is left.
+func InstanceTenant(id string) (tenant string, found bool, err error) {
+	err = db.QueryRow(`SELECT tenant FROM records WHERE id = ?
+		UNION ALL SELECT tenant FROM snapshots WHERE instance_id = ?
+		UNION ALL SELECT tenant FROM backup_jobs WHERE instance_id = ?
+		LIMIT 1`, id, id, id).Scan(&tenant)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return tenant, err == nil, err
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// setupDB points the package at a fresh database in a temporary directory
+func setupDB(t *testing.T) {
+	t.Helper()
+	dbPath = filepath.Join(t.TempDir(), "db.sqlite")
+	if err := InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+}
+
+func TestInstanceTenant(t *testing.T) {
+	setupDB(t)
+
+	if err := InsertData("abc123", "bucket", "10Gi", "local-pv", "acme", "miniomatic", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertData("def456", "bucket", "10Gi", "local-pv", "", "miniomatic", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertSnapshot(model.Snapshot{ID: "abc123-snapshot-1", InstanceID: "abc123", Storage: "10Gi", Retain: true, RootUser: "root"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertBackupJob(model.BackupJob{ID: "backup1", InstanceID: "def456", Type: "backup", Prefix: "def456/1", Status: "completed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := GetSnapshot("abc123-snapshot-1")
+	if err != nil || snapshot.Tenant != "acme" || snapshot.RootUser != "root" {
+		t.Fatalf("expected snapshot of tenant acme, got %+v %v", snapshot, err)
+	}
+	backup, err := GetBackupJob("backup1")
+	if err != nil || backup.Tenant != "" {
+		t.Fatalf("expected global backup, got %+v %v", backup, err)
+	}
+
+	// Deleted instances keep the tenant of what outlived them
+	for _, id := range []string{"abc123", "def456"} {
+		if err := DeleteData(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id     string
+		tenant string
+		found  bool
+	}{
+		{"abc123", "acme", true},
+		{"def456", "", true},
+		{"ghi789", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			tenant, found, err := InstanceTenant(tt.id)
+			if err != nil || tenant != tt.tenant || found != tt.found {
+				t.Fatalf("got %q %v %v, want %q %v", tenant, found, err, tt.tenant, tt.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// Webhook events, sent for the instance event of the same name except
+// ready, which is sent when the status of an instance changes to ready
+const (
+	WebhookCreated = "created"
+	WebhookReady   = "ready"
+	WebhookFailed  = "failed"
+	WebhookResized = "resized"
+	WebhookDeleted = "deleted"
+)
+
+// WebhookEvents lists the events webhooks can subscribe to
+var WebhookEvents = []string{WebhookCreated, WebhookReady, WebhookFailed, WebhookResized, WebhookDeleted}
+
+// webhookEvents maps instance events to the webhook event they trigger
+var webhookEvents = map[string]string{
+	EventCreated:    WebhookCreated,
+	EventFailed:     WebhookFailed,
+	EventResized:    WebhookResized,
+	EventAutoscaled: WebhookResized,
+	EventDeleted:    WebhookDeleted,
+}
+
+const webhooksTable = `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		tenant TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		created TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		instance_id TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt TEXT NOT NULL DEFAULT '',
+		response_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		created TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_pending ON webhook_deliveries (status, next_attempt);
+	`
+
+const webhookColumns = "id, tenant, url, events, created"
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (model.Webhook, error) {
+	var h model.Webhook
+	var events string
+	if err := row.Scan(&h.ID, &h.Tenant, &h.URL, &events, &h.Created); err != nil {
+		return h, err
+	}
+	h.Events = strings.Split(events, ",")
+	return h, nil
+}
+
+// InsertWebhook stores a webhook subscription
+func InsertWebhook(h model.Webhook) error {
+	_, err := db.Exec("INSERT INTO webhooks (id, tenant, url, secret, events, created) VALUES (?, ?, ?, ?, ?, ?)",
+		h.ID, h.Tenant, h.URL, h.Secret, strings.Join(h.Events, ","), h.Created)
+	return err
+}
+
+// GetWebhook returns a webhook by its ID without its secret, nil if it doesn't exist
+func GetWebhook(id string) (*model.Webhook, error) {
+	h, err := scanWebhook(db.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &h, nil
+}
+
+// GetWebhooks returns the webhooks of a tenant, or of every tenant when tenant is empty
+func GetWebhooks(tenant string) ([]model.Webhook, error) {
+	query, args := "SELECT "+webhookColumns+" FROM webhooks", []interface{}{}
+	if tenant != "" {
+		query, args = query+" WHERE tenant = ?", append(args, tenant)
+	}
+	rows, err := db.Query(query+" ORDER BY created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []model.Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook and its deliveries
+func DeleteWebhook(id string) error {
+	if _, err := db.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	return err
+}
+
+// queueWebhookDeliveries queues a delivery of an instance event for every
+// webhook subscribed to it. Webhooks of a tenant only receive events of its
+// instances, global webhooks receive all events.
+func queueWebhookDeliveries(id, event, message string) error {
+	var tenant string
+	if err := db.QueryRow("SELECT tenant FROM records WHERE id = ?", id).Scan(&tenant); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	rows, err := db.Query("SELECT id, events FROM webhooks WHERE tenant = '' OR tenant = ?", tenant)
+	if err != nil {
+		return err
+	}
+	var subscribed []string
+	for rows.Next() {
+		var hookID, events string
+		if err := rows.Scan(&hookID, &events); err != nil {
+			rows.Close()
+			return err
+		}
+		for _, e := range strings.Split(events, ",") {
+			if e == event {
+				subscribed = append(subscribed, hookID)
+				break
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(subscribed) == 0 {
+		return err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	payload, err := json.Marshal(model.WebhookPayload{Event: event, Instance: id, Tenant: tenant, Date: now, Message: message})
+	if err != nil {
+		return err
+	}
+	for _, hookID := range subscribed {
+		if _, err := db.Exec("INSERT INTO webhook_deliveries (webhook_id, event, instance_id, payload, next_attempt, created) VALUES (?, ?, ?, ?, ?, ?)",
+			hookID, event, id, string(payload), now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const webhookDeliveryColumns = "d.id, d.webhook_id, d.event, d.instance_id, d.payload, d.status, d.attempts, d.next_attempt, d.response_code, d.error, d.created"
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }, dest ...interface{}) (model.WebhookDelivery, error) {
+	var d model.WebhookDelivery
+	var payload string
+	err := row.Scan(append([]interface{}{&d.ID, &d.Webhook, &d.Event, &d.Instance, &payload, &d.Status, &d.Attempts, &d.NextAttempt, &d.ResponseCode, &d.Error, &d.Created}, dest...)...)
+	d.Payload = json.RawMessage(payload)
+	return d, err
+}
+
+// GetDueWebhookDeliveries returns pending deliveries whose next attempt is
+// at or before now, with the URL and secret of their webhook. Deliveries
+// queued after one of their webhook that is waiting for a retry are left
+// out, so they aren't sent before it.
+func GetDueWebhookDeliveries(now time.Time) ([]model.WebhookDelivery, error) {
+	due := now.Format("2006-01-02 15:04:05")
+	rows, err := db.Query("SELECT "+webhookDeliveryColumns+", h.url, h.secret FROM webhook_deliveries d JOIN webhooks h ON h.id = d.webhook_id WHERE d.status = 'pending' AND d.next_attempt <= ? AND NOT EXISTS (SELECT 1 FROM webhook_deliveries e WHERE e.webhook_id = d.webhook_id AND e.id < d.id AND e.status = 'pending' AND e.next_attempt > ?) ORDER BY d.id",
+		due, due)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []model.WebhookDelivery
+	for rows.Next() {
+		var url, secret string
+		d, err := scanWebhookDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, err
+		}
+		d.URL, d.Secret = url, secret
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ClaimWebhookDelivery counts an attempt at a delivery before it is sent.
+// It returns false when the delivery is no longer pending or another attempt
+// was made since it was read, so it isn't sent twice.
+func ClaimWebhookDelivery(d model.WebhookDelivery) (bool, error) {
+	res, err := db.Exec("UPDATE webhook_deliveries SET attempts = attempts + 1 WHERE id = ? AND status = 'pending' AND attempts = ?", d.ID, d.Attempts)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt. next is
+// when to retry, the zero time when the delivery is done.
+func UpdateWebhookDelivery(d model.WebhookDelivery, next time.Time) error {
+	nextAttempt := ""
+	if !next.IsZero() {
+		nextAttempt = next.Format("2006-01-02 15:04:05")
+	}
+	_, err := db.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt = ?, response_code = ?, error = ? WHERE id = ?",
+		d.Status, d.Attempts, nextAttempt, d.ResponseCode, d.Error, d.ID)
+	return err
+}
+
+// GetWebhookDeliveries returns the most recent deliveries of a webhook,
+// newest first, optionally limited to a status
+func GetWebhookDeliveries(webhookID, status string, limit int) ([]model.WebhookDelivery, error) {
+	query, args := "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries d WHERE d.webhook_id = ?", []interface{}{webhookID}
+	if status != "" {
+		query, args = query+" AND d.status = ?", append(args, status)
+	}
+	rows, err := db.Query(query+" ORDER BY d.id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
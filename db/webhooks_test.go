@@ -0,0 +1,74 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+func TestDueWebhookDeliveries(t *testing.T) {
+	setupDB(t)
+
+	if err := InsertWebhook(model.Webhook{ID: "hook1", URL: "https://example.com", Events: []string{WebhookCreated, WebhookDeleted}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []string{EventCreated, EventDeleted} {
+		if err := InsertEvent("abc123", event, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now().Add(time.Second)
+	due, err := GetDueWebhookDeliveries(now)
+	if err != nil || len(due) != 2 {
+		t.Fatalf("expected 2 due deliveries, got %d %v", len(due), err)
+	}
+
+	// A stale copy of a delivery can't be claimed again
+	if claimed, err := ClaimWebhookDelivery(due[0]); err != nil || !claimed {
+		t.Fatalf("expected claim, got %v %v", claimed, err)
+	}
+	if claimed, err := ClaimWebhookDelivery(due[0]); err != nil || claimed {
+		t.Fatalf("expected stale claim to fail, got %v %v", claimed, err)
+	}
+
+	// Later deliveries wait while the first one is retried
+	due[0].Attempts++
+	if err := UpdateWebhookDelivery(due[0], now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := GetDueWebhookDeliveries(now); err != nil || len(due) != 0 {
+		t.Fatalf("expected no due deliveries, got %d %v", len(due), err)
+	}
+
+	// Failed deliveries don't hold up the rest
+	due[0].Status = "failed"
+	if err := UpdateWebhookDelivery(due[0], time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := GetDueWebhookDeliveries(now); err != nil || len(due) != 1 || due[0].Event != WebhookDeleted {
+		t.Fatalf("expected the deleted delivery, got %+v %v", due, err)
+	}
+}
+
+func TestReadyWebhook(t *testing.T) {
+	setupDB(t)
+
+	if err := InsertWebhook(model.Webhook{ID: "hook1", URL: "https://example.com", Events: []string{WebhookReady}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertData("abc123", "bucket", "10Gi", "local-pv", "", "miniomatic", 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []string{"ready", "resizing", "ready", "migrating", "ready"} {
+		if err := UpdateStatus("abc123", status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, err := GetWebhookDeliveries("hook1", "", 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("expected a single ready delivery, got %d %v", len(deliveries), err)
+	}
+}
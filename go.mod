@@ -6,6 +6,8 @@ replace github.com/stenstromen/miniomatic => /
 
 require (
 	github.com/gorilla/mux v1.8.0
+	github.com/klauspost/compress v1.17.2
+	github.com/robfig/cron/v3 v3.0.1
 	k8s.io/api v0.28.3
 	k8s.io/apimachinery v0.28.3
 	k8s.io/utils v0.0.0-20230726121419-3b25d923346b
@@ -27,7 +29,6 @@ require (
 	github.com/google/gofuzz v1.2.0 // indirect
 	github.com/google/uuid v1.4.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
-	github.com/klauspost/compress v1.17.2 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.5 // indirect
 	github.com/lufia/plan9stats v0.0.0-20231016141302-07b5767bb0ed // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
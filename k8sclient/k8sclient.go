@@ -2,30 +2,50 @@ package k8sclient
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/stenstromen/miniomatic/db"
 	"github.com/stenstromen/miniomatic/model"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/utils/ptr"
 )
 
-const namespace = "miniomatic"
+const (
+	resizePollInterval = 5 * time.Second
+	resizeTimeout      = 10 * time.Minute
+	// fileSystemResizeGrace is how long the kubelet gets to grow the
+	// filesystem of a mounted volume before the pod is restarted, for
+	// drivers that only expand filesystems when a volume is mounted
+	fileSystemResizeGrace = 2 * time.Minute
+)
+
+var (
+	// ErrPVCShrink is returned when a resize would make the PVC smaller
+	ErrPVCShrink = errors.New("storage can only be increased")
+	// ErrPVCUnchanged is returned when a resize would keep the PVC size
+	ErrPVCUnchanged = errors.New("storage is unchanged")
+	// ErrVolumeExpansionNotAllowed is returned when the StorageClass of the PVC
+	// does not set allowVolumeExpansion
+	ErrVolumeExpansionNotAllowed = errors.New("storage class does not allow volume expansion")
+)
 
 func boolPtr(b bool) *bool { return &b }
 
-func getK8sClient() (*kubernetes.Clientset, error) {
+func getK8sConfig() *rest.Config {
 	configFile := os.Getenv("KUBECONFIG_FILE")
 	if configFile == "" {
 		configFile = filepath.Join(os.Getenv("HOME"), ".kube", "config")
@@ -34,31 +54,17 @@ func getK8sClient() (*kubernetes.Clientset, error) {
 	if err != nil {
 		log.Fatalf("failed to get Kubernetes config: %v", err)
 	}
-	client, err := kubernetes.NewForConfig(config)
+	return config
+}
+
+func getK8sClient() (*kubernetes.Clientset, error) {
+	client, err := kubernetes.NewForConfig(getK8sConfig())
 	if err != nil {
 		log.Fatalf("failed to create Kubernetes client: %v", err)
 	}
 	return client, nil
 }
 
-func ensureNamespace(client *kubernetes.Clientset) error {
-	_, err := client.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		_, err = client.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: namespace,
-			},
-		}, metav1.CreateOptions{})
-		if err != nil {
-			log.Fatalf("failed to create namespace %s: %v", namespace, err)
-		}
-		log.Printf("Created namespace %s", namespace)
-	} else if err != nil {
-		log.Fatalf("failed to get namespace %s: %v", namespace, err)
-	}
-	return nil
-}
-
 func createMinioSecret(client *kubernetes.Clientset, randnum, namespace, rootPassword string) error {
 	// Define the secret
 	secret := &corev1.Secret{
@@ -79,26 +85,187 @@ func createMinioSecret(client *kubernetes.Clientset, randnum, namespace, rootPas
 	return nil
 }
 
+// GetMinioCredentials reads the root credentials of an existing instance back
+// from its Deployment and Secret
+func GetMinioCredentials(randnum string) (model.Credentials, error) {
+	client, err := getK8sClient()
+	if err != nil {
+		return model.Credentials{}, err
+	}
+	namespace := instanceNamespace(randnum)
+
+	deployment, err := client.AppsV1().Deployments(namespace).Get(context.Background(), randnum+"-minio-deployment", metav1.GetOptions{})
+	if err != nil {
+		return model.Credentials{}, fmt.Errorf("failed to get deployment: %v", err)
+	}
+
+	var rootUser string
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		for _, env := range container.Env {
+			if env.Name == "MINIO_ROOT_USER" {
+				rootUser = env.Value
+			}
+		}
+	}
+	if rootUser == "" {
+		return model.Credentials{}, fmt.Errorf("MINIO_ROOT_USER not found in deployment %s", deployment.Name)
+	}
+
+	secret, err := client.CoreV1().Secrets(namespace).Get(context.Background(), randnum+"-minio-secrets", metav1.GetOptions{})
+	if err != nil {
+		return model.Credentials{}, fmt.Errorf("failed to get secret: %v", err)
+	}
+
+	return model.Credentials{
+		RandNum:      randnum,
+		RootUser:     rootUser,
+		RootPassword: string(secret.Data["rootPassword"]),
+	}, nil
+}
+
+// pvcName returns the name of the PVC mounted by the instance Deployment, which
+// changes when the instance is migrated to another storage class
+func pvcName(client *kubernetes.Clientset, namespace, randnum string) (string, error) {
+	deployment, err := client.AppsV1().Deployments(namespace).Get(context.Background(), randnum+"-minio-deployment", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return randnum + "-minio-pvc", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get deployment: %v", err)
+	}
+
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.Name == "data" && volume.PersistentVolumeClaim != nil {
+			return volume.PersistentVolumeClaim.ClaimName, nil
+		}
+	}
+	return randnum + "-minio-pvc", nil
+}
+
+func getMinioPVC(client *kubernetes.Clientset, randnum string) (*corev1.PersistentVolumeClaim, error) {
+	namespace := instanceNamespace(randnum)
+	name, err := pvcName(client, namespace, randnum)
+	if err != nil {
+		return nil, err
+	}
+	return client.CoreV1().PersistentVolumeClaims(namespace).Get(context.Background(), name, metav1.GetOptions{})
+}
+
+// GetPVCCapacity returns the provisioned capacity of an instance PVC, falling
+// back to the requested size while the volume is not bound
+func GetPVCCapacity(randnum string) (resource.Quantity, error) {
+	client, err := getK8sClient()
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+
+	pvc, err := getMinioPVC(client, randnum)
+	if err != nil {
+		return resource.Quantity{}, fmt.Errorf("failed to get PVC: %v", err)
+	}
+
+	if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		return capacity, nil
+	}
+	return pvc.Spec.Resources.Requests[corev1.ResourceStorage], nil
+}
+
+// ResizeMinioPVC requests a new size for the PVC of an instance after checking
+// that it grows and that its StorageClass allows expansion. Use
+// WaitForPVCResize to follow the resize until it completes.
 func ResizeMinioPVC(randnum, storage string) error {
+	client, err := getK8sClient()
+	if err != nil {
+		return err
+	}
+
+	pvc, err := getMinioPVC(client, randnum)
+	if err != nil {
+		return fmt.Errorf("failed to get PVC: %v", err)
+	}
+
+	newSize, err := resource.ParseQuantity(storage)
+	if err != nil {
+		return err
+	}
+	currentSize := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	switch newSize.Cmp(currentSize) {
+	case -1:
+		return ErrPVCShrink
+	case 0:
+		return ErrPVCUnchanged
+	}
+
+	if pvc.Spec.StorageClassName == nil {
+		return ErrVolumeExpansionNotAllowed
+	}
+	storageClass, err := client.StorageV1().StorageClasses().Get(context.Background(), *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get storage class: %v", err)
+	}
+	if storageClass.AllowVolumeExpansion == nil || !*storageClass.AllowVolumeExpansion {
+		return ErrVolumeExpansionNotAllowed
+	}
+
 	if err := db.UpdateStatus(randnum, "resizing"); err != nil {
 		return fmt.Errorf("failed to update status to resizing: %v", err)
 	}
 
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = newSize
+
+	_, err = client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(context.Background(), pvc, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update PVC: %v", err)
+	}
+
+	return nil
+}
+
+// WaitForPVCResize follows a resized PVC through controller and filesystem
+// expansion until its capacity matches the requested size, then marks the
+// instance ready. When filesystem expansion stays pending, the pod is
+// restarted once so the kubelet grows the filesystem while mounting it.
+func WaitForPVCResize(randnum, storage string) error {
 	client, err := getK8sClient()
 	if err != nil {
 		return err
 	}
 
-	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(context.Background(), randnum+"-minio-pvc", metav1.GetOptions{})
+	requested, err := resource.ParseQuantity(storage)
 	if err != nil {
-		log.Fatalf("failed to get PVC: %v", err)
+		return err
 	}
 
-	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse(storage)
+	deadline := time.Now().Add(resizeTimeout)
+	var pendingSince time.Time
+	restarted := false
+	for {
+		pvc, err := getMinioPVC(client, randnum)
+		if err != nil {
+			return fmt.Errorf("failed to get PVC: %v", err)
+		}
 
-	_, err = client.CoreV1().PersistentVolumeClaims(namespace).Update(context.Background(), pvc, metav1.UpdateOptions{})
-	if err != nil {
-		log.Fatalf("failed to update PVC: %v", err)
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok && capacity.Cmp(requested) >= 0 && !resizeInProgress(pvc) {
+			break
+		}
+
+		if pvcCondition(pvc, corev1.PersistentVolumeClaimFileSystemResizePending) {
+			if pendingSince.IsZero() {
+				pendingSince = time.Now()
+			}
+			if !restarted && time.Since(pendingSince) > fileSystemResizeGrace {
+				log.Printf("Filesystem resize of PVC %s is still pending, restarting instance %s", pvc.Name, randnum)
+				if err := restartMinioPods(client, randnum); err != nil {
+					return err
+				}
+				restarted = true
+				deadline = time.Now().Add(resizeTimeout)
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for PVC %s to reach %s", pvc.Name, storage)
+		}
+		time.Sleep(resizePollInterval)
 	}
 
 	if err := db.UpdateStatus(randnum, "ready"); err != nil {
@@ -108,9 +275,62 @@ func ResizeMinioPVC(randnum, storage string) error {
 	return nil
 }
 
-func CreateMinioResources(creds model.Credentials, clusterIssuer, storageClassName, storage string) error {
+func resizeInProgress(pvc *corev1.PersistentVolumeClaim) bool {
+	return pvcCondition(pvc, corev1.PersistentVolumeClaimResizing) || pvcCondition(pvc, corev1.PersistentVolumeClaimFileSystemResizePending)
+}
+
+func pvcCondition(pvc *corev1.PersistentVolumeClaim, conditionType corev1.PersistentVolumeClaimConditionType) bool {
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// restartMinioPods deletes the pods of an instance so its Deployment
+// recreates them
+func restartMinioPods(client *kubernetes.Clientset, randnum string) error {
+	err := client.CoreV1().Pods(instanceNamespace(randnum)).DeleteCollection(context.Background(), metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: "app=" + randnum + "minio"})
+	if err != nil {
+		return fmt.Errorf("failed to restart pods: %v", err)
+	}
+	return nil
+}
+
+// SnapshotDataSource returns a PVC data source restoring a VolumeSnapshot
+func SnapshotDataSource(name string) *corev1.TypedLocalObjectReference {
+	return &corev1.TypedLocalObjectReference{
+		APIGroup: ptr.To(volumeSnapshots.Group),
+		Kind:     "VolumeSnapshot",
+		Name:     name,
+	}
+}
+
+// InstanceDataSource returns a PVC data source cloning the volume of an
+// existing instance
+func InstanceDataSource(randnum string) (*corev1.TypedLocalObjectReference, error) {
+	client, err := getK8sClient()
+	if err != nil {
+		return nil, err
+	}
+	pvc, err := getMinioPVC(client, randnum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PVC: %v", err)
+	}
+	return &corev1.TypedLocalObjectReference{
+		Kind: "PersistentVolumeClaim",
+		Name: pvc.Name,
+	}, nil
+}
+
+// CreateMinioResources creates the Kubernetes resources of an instance in
+// its namespace. When dataSource is set the PVC is populated from it instead
+// of starting empty.
+func CreateMinioResources(creds model.Credentials, ns model.Namespace, clusterIssuer, storageClassName, storage string, dataSource *corev1.TypedLocalObjectReference) error {
 	randnum, rootUser, rootPassword := creds.RandNum, creds.RootUser, creds.RootPassword
 	wildcard_domain := randnum + "." + os.Getenv("WILDCARD_DOMAIN")
+	namespace := ns.Name
 
 	// Get the Kubernetes configuration.
 	client, err := getK8sClient()
@@ -118,13 +338,13 @@ func CreateMinioResources(creds model.Credentials, clusterIssuer, storageClassNa
 		return err
 	}
 
-	// Create the Minio Secret
-	if err := createMinioSecret(client, randnum, namespace, rootPassword); err != nil {
-		log.Fatalf("Failed to create secret: %v", err)
+	if err := ensureNamespace(client, ns, randnum); err != nil {
 		return err
 	}
 
-	if err := ensureNamespace(client); err != nil {
+	// Create the Minio Secret
+	if err := createMinioSecret(client, randnum, namespace, rootPassword); err != nil {
+		log.Fatalf("Failed to create secret: %v", err)
 		return err
 	}
 
@@ -316,6 +536,7 @@ func CreateMinioResources(creds model.Credentials, clusterIssuer, storageClassNa
 					corev1.ResourceStorage: resource.MustParse(storage),
 				},
 			},
+			DataSource: dataSource,
 		},
 	}
 	_, err = client.CoreV1().PersistentVolumeClaims(namespace).Create(context.TODO(), pvc, metav1.CreateOptions{})
@@ -326,12 +547,19 @@ func CreateMinioResources(creds model.Credentials, clusterIssuer, storageClassNa
 	return nil
 }
 
-func DeleteMinioResources(randnum string) error {
+// DeleteMinioResources deletes the Kubernetes resources of an instance from
+// its namespace
+func DeleteMinioResources(randnum, namespace string) error {
 	client, err := getK8sClient()
 	if err != nil {
 		return err
 	}
 
+	pvc, err := pvcName(client, namespace, randnum)
+	if err != nil {
+		return err
+	}
+
 	// Delete Ingress
 	err = client.NetworkingV1().Ingresses(namespace).Delete(context.TODO(), randnum+"-minio-ingress", metav1.DeleteOptions{})
 	if err != nil {
@@ -363,7 +591,7 @@ func DeleteMinioResources(randnum string) error {
 	}
 
 	// Delete PVC
-	err = client.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), randnum+"-minio-pvc", metav1.DeleteOptions{})
+	err = client.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), pvc, metav1.DeleteOptions{})
 	if err != nil {
 		log.Fatalln("failed to delete PVC:", err)
 	}
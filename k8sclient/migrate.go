@@ -0,0 +1,246 @@
+package k8sclient
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/stenstromen/miniomatic/db"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	migrateImage   = "alpine:latest"
+	migrateTimeout = time.Hour
+)
+
+// StorageClassExists reports whether a StorageClass exists in the cluster
+func StorageClassExists(name string) (bool, error) {
+	client, err := getK8sClient()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = client.StorageV1().StorageClasses().Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get storage class: %v", err)
+	}
+	return true, nil
+}
+
+// MigrateStorageClass moves the data of an instance to a new PVC on another
+// StorageClass. The instance is stopped, its data copied by a Job, and the
+// Deployment switched to the new PVC before the old PVC is deleted. On
+// failure the instance is started again on the old PVC and the error is
+// returned so the caller can mark the instance failed.
+func MigrateStorageClass(randnum, storageClass string) error {
+	client, err := getK8sClient()
+	if err != nil {
+		return err
+	}
+
+	oldPVC, err := getMinioPVC(client, randnum)
+	if err != nil {
+		return fmt.Errorf("failed to get PVC: %v", err)
+	}
+
+	newName := randnum + "-minio-pvc-" + storageClass
+	if oldPVC.Name == newName {
+		newName = randnum + "-minio-pvc"
+	}
+	newPVC := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: newName,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: &storageClass,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: oldPVC.Spec.Resources.Requests[corev1.ResourceStorage],
+				},
+			},
+		},
+	}
+
+	// Until the Deployment uses the new PVC, any failure, including a
+	// timeout while stopping the instance, starts it again on the old one
+	switched := false
+	defer func() {
+		if !switched {
+			rollbackMigration(client, randnum, newName)
+		}
+	}()
+
+	if err := scaleDeployment(client, randnum, 0); err != nil {
+		return err
+	}
+
+	if err := copyPVC(client, randnum, oldPVC.Name, newPVC); err != nil {
+		return err
+	}
+
+	if err := switchPVC(client, randnum, newName); err != nil {
+		return err
+	}
+	switched = true
+
+	if err := client.CoreV1().PersistentVolumeClaims(oldPVC.Namespace).Delete(context.Background(), oldPVC.Name, metav1.DeleteOptions{}); err != nil {
+		log.Printf("failed to delete old PVC %s: %v", oldPVC.Name, err)
+	}
+
+	if err := db.UpdateStorageClass(randnum, storageClass); err != nil {
+		return fmt.Errorf("failed to update storage class: %v", err)
+	}
+	return nil
+}
+
+// switchPVC points the data volume of the Deployment at another PVC and
+// starts the instance again
+func switchPVC(client *kubernetes.Clientset, randnum, claimName string) error {
+	namespace := instanceNamespace(randnum)
+	deployment, err := client.AppsV1().Deployments(namespace).Get(context.Background(), randnum+"-minio-deployment", metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get deployment: %v", err)
+	}
+	for i, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.Name == "data" && volume.PersistentVolumeClaim != nil {
+			deployment.Spec.Template.Spec.Volumes[i].PersistentVolumeClaim.ClaimName = claimName
+		}
+	}
+	deployment.Spec.Replicas = ptr.To(int32(1))
+	if _, err := client.AppsV1().Deployments(namespace).Update(context.Background(), deployment, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update deployment: %v", err)
+	}
+	return nil
+}
+
+// rollbackMigration removes the new PVC and starts the instance on the old one
+func rollbackMigration(client *kubernetes.Clientset, randnum, newName string) {
+	namespace := instanceNamespace(randnum)
+	if err := client.CoreV1().PersistentVolumeClaims(namespace).Delete(context.Background(), newName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		log.Printf("failed to delete PVC %s: %v", newName, err)
+	}
+	if err := scaleDeployment(client, randnum, 1); err != nil {
+		log.Printf("failed to restart instance %s after failed migration: %v", randnum, err)
+	}
+}
+
+func scaleDeployment(client *kubernetes.Clientset, randnum string, replicas int32) error {
+	namespace := instanceNamespace(randnum)
+	name := randnum + "-minio-deployment"
+	scale, err := client.AppsV1().Deployments(namespace).GetScale(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get deployment scale: %v", err)
+	}
+	scale.Spec.Replicas = replicas
+	if _, err := client.AppsV1().Deployments(namespace).UpdateScale(context.Background(), name, scale, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to scale deployment: %v", err)
+	}
+
+	if replicas > 0 {
+		return nil
+	}
+
+	// Wait for the pod to release the volume
+	deadline := time.Now().Add(resizeTimeout)
+	for {
+		pods, err := client.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: "app=" + randnum + "minio"})
+		if err != nil {
+			return fmt.Errorf("failed to list pods: %v", err)
+		}
+		if len(pods.Items) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for instance %s to stop", randnum)
+		}
+		time.Sleep(resizePollInterval)
+	}
+}
+
+// copyPVC creates the destination PVC and runs a Job copying all data from
+// the source PVC into it
+func copyPVC(client *kubernetes.Clientset, randnum, from string, to *corev1.PersistentVolumeClaim) error {
+	namespace := instanceNamespace(randnum)
+	if _, err := client.CoreV1().PersistentVolumeClaims(namespace).Create(context.Background(), to, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create PVC: %v", err)
+	}
+
+	jobName := randnum + "-minio-migrate"
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: jobName,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            ptr.To(int32(2)),
+			TTLSecondsAfterFinished: ptr.To(int32(600)),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					AutomountServiceAccountToken: boolPtr(false),
+					RestartPolicy:                corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "copy",
+							Image:   migrateImage,
+							Command: []string{"sh", "-c", "cp -a /from/. /to/"},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "from", MountPath: "/from", ReadOnly: true},
+								{Name: "to", MountPath: "/to"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "from",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: from, ReadOnly: true},
+							},
+						},
+						{
+							Name: "to",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: to.Name},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if _, err := client.BatchV1().Jobs(namespace).Create(context.Background(), job, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create copy job: %v", err)
+	}
+	defer client.BatchV1().Jobs(namespace).Delete(context.Background(), jobName, metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationBackground)})
+
+	deadline := time.Now().Add(migrateTimeout)
+	for {
+		job, err := client.BatchV1().Jobs(namespace).Get(context.Background(), jobName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get copy job: %v", err)
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobComplete:
+				return nil
+			case batchv1.JobFailed:
+				return fmt.Errorf("copy job failed: %s", condition.Message)
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for copy job %s", jobName)
+		}
+		time.Sleep(resizePollInterval)
+	}
+}
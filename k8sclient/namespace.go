@@ -0,0 +1,230 @@
+package k8sclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/stenstromen/miniomatic/db"
+	"github.com/stenstromen/miniomatic/model"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SharedNamespace is the namespace instances run in unless NAMESPACE_MODE
+// gives them one of their own
+const SharedNamespace = "miniomatic"
+
+const (
+	resourceQuotaName = "miniomatic-quota"
+	limitRangeName    = "miniomatic-limits"
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+	// instanceLabel marks namespaces created for a single instance, which are
+	// deleted along with it
+	instanceLabel = "miniomatic/instance"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// NamespaceFor returns the namespace a new instance runs in. NAMESPACE_MODE
+// "tenant" gives every tenant a namespace and "instance" every instance,
+// anything else keeps all instances in the shared namespace. Instances of
+// global callers always use the shared namespace in tenant mode.
+func NamespaceFor(tenant, randnum string) string {
+	switch os.Getenv("NAMESPACE_MODE") {
+	case "tenant":
+		if tenant != "" {
+			return tenantNamespace(tenant)
+		}
+	case "instance":
+		return SharedNamespace + "-" + randnum
+	}
+	return SharedNamespace
+}
+
+// InstanceNamespaces reports whether NAMESPACE_MODE gives every new
+// instance a namespace of its own
+func InstanceNamespaces() bool {
+	return os.Getenv("NAMESPACE_MODE") == "instance"
+}
+
+// tenantNamespace turns a tenant into a valid namespace name. Tenants that
+// had to be altered get a hash suffix so they can't collide.
+func tenantNamespace(tenant string) string {
+	name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(tenant), "-"), "-")
+	if name == tenant && len(SharedNamespace)+1+len(name) <= 63 {
+		return SharedNamespace + "-" + name
+	}
+
+	sum := sha256.Sum256([]byte(tenant))
+	suffix := hex.EncodeToString(sum[:4])
+	if max := 63 - len(SharedNamespace) - len(suffix) - 2; len(name) > max {
+		name = strings.Trim(name[:max], "-")
+	}
+	if name == "" {
+		return SharedNamespace + "-" + suffix
+	}
+	return SharedNamespace + "-" + name + "-" + suffix
+}
+
+// instanceNamespace returns the namespace an existing instance runs in
+func instanceNamespace(randnum string) string {
+	if record, err := db.GetDataByID(randnum); err == nil && record != nil && record.Namespace != "" {
+		return record.Namespace
+	}
+	return SharedNamespace
+}
+
+// ensureNamespace creates the namespace of an instance when it doesn't exist
+// yet and applies its ResourceQuota and LimitRange. The shared namespace is
+// left as it is.
+func ensureNamespace(client *kubernetes.Clientset, ns model.Namespace, randnum string) error {
+	_, err := client.CoreV1().Namespaces().Get(context.Background(), ns.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		labels := map[string]string{managedByLabel: "miniomatic"}
+		if ns.Name == SharedNamespace+"-"+randnum {
+			labels[instanceLabel] = randnum
+		}
+		_, err = client.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   ns.Name,
+				Labels: labels,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create namespace %s: %v", ns.Name, err)
+		}
+		log.Printf("Created namespace %s", ns.Name)
+	} else if err != nil {
+		return fmt.Errorf("failed to get namespace %s: %v", ns.Name, err)
+	}
+
+	if ns.Name == SharedNamespace {
+		return nil
+	}
+	return applyNamespacePolicy(client, ns)
+}
+
+// ApplyNamespacePolicy updates the ResourceQuota and LimitRange of an
+// existing namespace, removing them when they are no longer set
+func ApplyNamespacePolicy(ns model.Namespace) error {
+	if ns.Name == SharedNamespace {
+		return nil
+	}
+	client, err := getK8sClient()
+	if err != nil {
+		return err
+	}
+	return applyNamespacePolicy(client, ns)
+}
+
+func applyNamespacePolicy(client *kubernetes.Clientset, ns model.Namespace) error {
+	quotas := client.CoreV1().ResourceQuotas(ns.Name)
+	if len(ns.ResourceQuota) == 0 {
+		if err := quotas.Delete(context.Background(), resourceQuotaName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete resource quota: %v", err)
+		}
+	} else {
+		hard, err := ResourceList(ns.ResourceQuota)
+		if err != nil {
+			return err
+		}
+		quota, err := quotas.Get(context.Background(), resourceQuotaName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = quotas.Create(context.Background(), &corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceQuotaName},
+				Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+			}, metav1.CreateOptions{})
+		} else if err == nil {
+			quota.Spec.Hard = hard
+			_, err = quotas.Update(context.Background(), quota, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply resource quota: %v", err)
+		}
+	}
+
+	limitRanges := client.CoreV1().LimitRanges(ns.Name)
+	if ns.LimitRange == nil {
+		if err := limitRanges.Delete(context.Background(), limitRangeName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete limit range: %v", err)
+		}
+		return nil
+	}
+
+	item := corev1.LimitRangeItem{Type: corev1.LimitTypeContainer}
+	var err error
+	if item.Default, err = ResourceList(ns.LimitRange.Default); err != nil {
+		return err
+	}
+	if item.DefaultRequest, err = ResourceList(ns.LimitRange.DefaultRequest); err != nil {
+		return err
+	}
+	if item.Max, err = ResourceList(ns.LimitRange.Max); err != nil {
+		return err
+	}
+	limitRange, err := limitRanges.Get(context.Background(), limitRangeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = limitRanges.Create(context.Background(), &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: limitRangeName},
+			Spec:       corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{item}},
+		}, metav1.CreateOptions{})
+	} else if err == nil {
+		limitRange.Spec.Limits = []corev1.LimitRangeItem{item}
+		_, err = limitRanges.Update(context.Background(), limitRange, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply limit range: %v", err)
+	}
+	return nil
+}
+
+// ResourceList parses resource names and quantities such as requests.storage: 100Gi
+func ResourceList(values map[string]string) (corev1.ResourceList, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	list := make(corev1.ResourceList, len(values))
+	for name, value := range values {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity %q for %s", value, name)
+		}
+		list[corev1.ResourceName(name)] = quantity
+	}
+	return list, nil
+}
+
+// DeleteInstanceNamespace deletes a namespace created for a single instance.
+// Shared and tenant namespaces are kept.
+func DeleteInstanceNamespace(name string) error {
+	client, err := getK8sClient()
+	if err != nil {
+		return err
+	}
+
+	ns, err := client.CoreV1().Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+	if _, ok := ns.Labels[instanceLabel]; !ok {
+		return nil
+	}
+
+	if err := client.CoreV1().Namespaces().Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete namespace %s: %v", name, err)
+	}
+	log.Printf("Deleted namespace %s", name)
+	return nil
+}
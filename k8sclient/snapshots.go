@@ -0,0 +1,114 @@
+package k8sclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stenstromen/miniomatic/model"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// volumeSnapshots is the CSI external-snapshotter resource. It isn't part of
+// client-go, so it's handled through the dynamic client.
+var volumeSnapshots = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+func getDynamicClient() (dynamic.Interface, error) {
+	client, err := dynamic.NewForConfig(getK8sConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes dynamic client: %v", err)
+	}
+	return client, nil
+}
+
+// CreateVolumeSnapshot creates a VolumeSnapshot of the PVC of an instance
+// next to it. An empty SnapshotClass uses the default VolumeSnapshotClass of
+// the cluster. The size and namespace of the snapshotted PVC are filled in.
+func CreateVolumeSnapshot(snapshot *model.Snapshot) error {
+	randnum, name, snapshotClass := snapshot.InstanceID, snapshot.ID, snapshot.SnapshotClass
+
+	client, err := getK8sClient()
+	if err != nil {
+		return err
+	}
+	pvc, err := getMinioPVC(client, randnum)
+	if err != nil {
+		return fmt.Errorf("failed to get PVC: %v", err)
+	}
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": pvc.Name,
+		},
+	}
+	if snapshotClass != "" {
+		spec["volumeSnapshotClassName"] = snapshotClass
+	}
+	volumeSnapshot := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": volumeSnapshots.GroupVersion().String(),
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name": name,
+				"labels": map[string]interface{}{
+					"app": randnum + "minio",
+				},
+			},
+			"spec": spec,
+		},
+	}
+
+	dynamicClient, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+	if _, err := dynamicClient.Resource(volumeSnapshots).Namespace(pvc.Namespace).Create(context.Background(), volumeSnapshot, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create volume snapshot: %v", err)
+	}
+
+	snapshot.Storage, snapshot.Namespace = pvc.Spec.Resources.Requests.Storage().String(), pvc.Namespace
+	return nil
+}
+
+// GetVolumeSnapshotStatus fills in the readiness, restore size and error of a
+// snapshot from its VolumeSnapshot
+func GetVolumeSnapshotStatus(snapshot *model.Snapshot) error {
+	dynamicClient, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	obj, err := dynamicClient.Resource(volumeSnapshots).Namespace(snapshot.Namespace).Get(context.Background(), snapshot.ID, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		snapshot.Error = "volume snapshot not found"
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get volume snapshot: %v", err)
+	}
+
+	snapshot.ReadyToUse, _, _ = unstructured.NestedBool(obj.Object, "status", "readyToUse")
+	snapshot.RestoreSize, _, _ = unstructured.NestedString(obj.Object, "status", "restoreSize")
+	snapshot.Error, _, _ = unstructured.NestedString(obj.Object, "status", "error", "message")
+	return nil
+}
+
+// DeleteVolumeSnapshot deletes a VolumeSnapshot, a missing snapshot is not an error
+func DeleteVolumeSnapshot(namespace, name string) error {
+	dynamicClient, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	err = dynamicClient.Resource(volumeSnapshots).Namespace(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete volume snapshot: %v", err)
+	}
+	return nil
+}
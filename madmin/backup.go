@@ -0,0 +1,175 @@
+package madmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// ErrBackupNotConfigured is returned when no backup target is set in the environment
+var ErrBackupNotConfigured = errors.New("backup target is not configured")
+
+// newBackupClient returns an S3 client for the backup target and the bucket
+// backups are stored in
+func newBackupClient() (*minio.Client, string, error) {
+	endpoint, bucket := os.Getenv("BACKUP_ENDPOINT"), os.Getenv("BACKUP_BUCKET")
+	if endpoint == "" || bucket == "" {
+		return nil, "", ErrBackupNotConfigured
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv("BACKUP_ACCESS_KEY"), os.Getenv("BACKUP_SECRET_KEY"), ""),
+		Secure: os.Getenv("BACKUP_USE_SSL") != "false",
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create backup client: %v", err)
+	}
+	return client, bucket, nil
+}
+
+// Backup mirrors every bucket of an instance to the backup target, objects
+// are stored as <prefix>/<bucket>/<object>. Only the latest version of each
+// object is copied.
+func Backup(creds model.Credentials, prefix string, progress func(objects, bytes int64)) error {
+	target, targetBucket, err := newBackupClient()
+	if err != nil {
+		return err
+	}
+	source, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if err := ensureBucket(target, targetBucket); err != nil {
+		return err
+	}
+
+	buckets, err := source.ListBuckets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list buckets: %v", err)
+	}
+
+	var objects, bytes int64
+	for _, bucket := range buckets {
+		for info := range source.ListObjects(ctx, bucket.Name, minio.ListObjectsOptions{Recursive: true}) {
+			if info.Err != nil {
+				return fmt.Errorf("failed to list objects of %s: %v", bucket.Name, info.Err)
+			}
+			key := prefix + "/" + bucket.Name + "/" + info.Key
+			if err := copyObject(source, bucket.Name, info.Key, target, targetBucket, key); err != nil {
+				return err
+			}
+			objects, bytes = objects+1, bytes+info.Size
+			progress(objects, bytes)
+		}
+	}
+	return nil
+}
+
+// Restore copies the buckets of a backup into an instance, creating buckets
+// that don't exist. Existing objects with the same name are overwritten.
+func Restore(creds model.Credentials, prefix string, progress func(objects, bytes int64)) error {
+	source, sourceBucket, err := newBackupClient()
+	if err != nil {
+		return err
+	}
+	target, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	created := map[string]bool{}
+
+	var objects, bytes int64
+	for info := range source.ListObjects(ctx, sourceBucket, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true}) {
+		if info.Err != nil {
+			return fmt.Errorf("failed to list backup objects: %v", info.Err)
+		}
+		bucket, key, ok := strings.Cut(strings.TrimPrefix(info.Key, prefix+"/"), "/")
+		if !ok {
+			continue
+		}
+		if !created[bucket] {
+			if err := makeBucket(target, bucket, model.BucketOptions{}); err != nil && !errors.Is(err, ErrBucketExists) {
+				return err
+			}
+			created[bucket] = true
+		}
+		if err := copyObject(source, sourceBucket, info.Key, target, bucket, key); err != nil {
+			return err
+		}
+		objects, bytes = objects+1, bytes+info.Size
+		progress(objects, bytes)
+	}
+	return nil
+}
+
+// DeleteBackup removes all objects of a backup from the backup target
+func DeleteBackup(prefix string) error {
+	client, bucket, err := newBackupClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	objects := client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true})
+	for result := range client.RemoveObjects(ctx, bucket, objects, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			return fmt.Errorf("failed to delete backup object %s: %v", result.ObjectName, result.Err)
+		}
+	}
+	return nil
+}
+
+func ensureBucket(client *minio.Client, bucket string) error {
+	exists, err := client.BucketExists(context.Background(), bucket)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket %s: %v", bucket, err)
+	}
+	if exists {
+		return nil
+	}
+	if err := client.MakeBucket(context.Background(), bucket, minio.MakeBucketOptions{Region: location}); err != nil {
+		return fmt.Errorf("failed to create bucket %s: %v", bucket, err)
+	}
+	return nil
+}
+
+// copyObject streams an object between two S3 endpoints, keeping its content
+// type and user metadata
+func copyObject(source *minio.Client, sourceBucket, sourceKey string, target *minio.Client, targetBucket, targetKey string) error {
+	ctx := context.Background()
+
+	object, err := source.GetObject(ctx, sourceBucket, sourceKey, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get object %s/%s: %v", sourceBucket, sourceKey, err)
+	}
+	defer object.Close()
+
+	info, err := object.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat object %s/%s: %v", sourceBucket, sourceKey, err)
+	}
+
+	_, err = target.PutObject(ctx, targetBucket, targetKey, object, info.Size, minio.PutObjectOptions{
+		ContentType:  info.ContentType,
+		UserMetadata: info.UserMetadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object %s/%s: %v", targetBucket, targetKey, err)
+	}
+	return nil
+}
+
+// BackupConfigured reports whether a backup target is set in the environment
+func BackupConfigured() bool {
+	return os.Getenv("BACKUP_ENDPOINT") != "" && os.Getenv("BACKUP_BUCKET") != ""
+}
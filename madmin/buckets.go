@@ -0,0 +1,153 @@
+package madmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const location = "eu-north-1"
+
+var (
+	// ErrBucketNotFound is returned when a bucket does not exist on the instance
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrBucketExists is returned when creating a bucket that already exists
+	ErrBucketExists = errors.New("bucket already exists")
+	// ErrBucketNotEmpty is returned when deleting a bucket that still holds objects
+	ErrBucketNotEmpty = errors.New("bucket is not empty")
+)
+
+// newMinioClient returns an S3 client authenticated as the instance root user
+func newMinioClient(creds model.Credentials) (*minio.Client, error) {
+	return minio.New(endpoint(creds.RandNum), &minio.Options{
+		Creds:  credentials.NewStaticV4(creds.RootUser, creds.RootPassword, ""),
+		Secure: useSSL,
+	})
+}
+
+// MakeBucket creates a bucket on the instance and applies the bucket options
+func MakeBucket(creds model.Credentials, bucket string, opts model.BucketOptions) error {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+	return makeBucket(client, bucket, opts)
+}
+
+// ListBuckets returns the details of all buckets on the instance
+func ListBuckets(creds model.Credentials) ([]model.Bucket, error) {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := client.ListBuckets(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %v", err)
+	}
+
+	buckets := make([]model.Bucket, 0, len(infos))
+	for _, info := range infos {
+		bucket, err := bucketDetails(client, info)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// GetBucket returns the details of a single bucket on the instance
+func GetBucket(creds model.Credentials, bucket string) (model.Bucket, error) {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return model.Bucket{}, err
+	}
+
+	infos, err := client.ListBuckets(context.Background())
+	if err != nil {
+		return model.Bucket{}, fmt.Errorf("failed to list buckets: %v", err)
+	}
+	for _, info := range infos {
+		if info.Name == bucket {
+			return bucketDetails(client, info)
+		}
+	}
+	return model.Bucket{}, ErrBucketNotFound
+}
+
+// RemoveBucket deletes an empty bucket from the instance
+func RemoveBucket(creds model.Credentials, bucket string) error {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+
+	if err := client.RemoveBucket(context.Background(), bucket); err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "NoSuchBucket":
+			return ErrBucketNotFound
+		case "BucketNotEmpty":
+			return ErrBucketNotEmpty
+		}
+		return fmt.Errorf("failed to remove bucket: %v", err)
+	}
+	return nil
+}
+
+func makeBucket(client *minio.Client, bucket string, opts model.BucketOptions) error {
+	err := client.MakeBucket(context.Background(), bucket, minio.MakeBucketOptions{Region: location, ObjectLocking: opts.ObjectLock})
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "BucketAlreadyOwnedByYou", "BucketAlreadyExists":
+			return ErrBucketExists
+		}
+		return fmt.Errorf("failed to create bucket: %v", err)
+	}
+
+	// Object locking enables versioning on its own
+	if opts.Versioning && !opts.ObjectLock {
+		if err := client.EnableVersioning(context.Background(), bucket); err != nil {
+			return fmt.Errorf("failed to enable versioning: %v", err)
+		}
+	}
+
+	if opts.RetentionMode != "" {
+		mode, unit := minio.RetentionMode(opts.RetentionMode), minio.ValidityUnit(opts.RetentionUnit)
+		validity := opts.RetentionValidity
+		if err := client.SetObjectLockConfig(context.Background(), bucket, &mode, &validity, &unit); err != nil {
+			return fmt.Errorf("failed to set default retention: %v", err)
+		}
+	}
+	return nil
+}
+
+func bucketDetails(client *minio.Client, info minio.BucketInfo) (model.Bucket, error) {
+	bucket := model.Bucket{
+		Name:    info.Name,
+		Created: info.CreationDate.Format("2006-01-02 15:04:05"),
+	}
+
+	versioning, err := client.GetBucketVersioning(context.Background(), info.Name)
+	if err != nil {
+		return model.Bucket{}, fmt.Errorf("failed to get bucket versioning: %v", err)
+	}
+	bucket.Versioning = versioning.Status
+
+	objectLock, mode, validity, unit, err := client.GetObjectLockConfig(context.Background(), info.Name)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "ObjectLockConfigurationNotFoundError" {
+			return bucket, nil
+		}
+		return model.Bucket{}, fmt.Errorf("failed to get object lock configuration: %v", err)
+	}
+	bucket.ObjectLock = objectLock == "Enabled"
+	if mode != nil && validity != nil && unit != nil {
+		bucket.RetentionMode, bucket.RetentionValidity, bucket.RetentionUnit = mode.String(), *validity, unit.String()
+	}
+	return bucket, nil
+}
@@ -0,0 +1,79 @@
+package madmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// Clone prepares an instance whose volume was cloned from another instance.
+// The users and service accounts copied along with the data are removed, so
+// the keys of the source instance don't work on the clone, and a fresh user
+// key pair is created. SourceRootUser, when known, is the root user of the
+// source, whose service accounts are removed as well. The cloned buckets are
+// kept, BucketName is only created when it doesn't exist.
+func Clone(creds model.Credentials, SourceRootUser, BucketName, AccessKey, SecretKey string, Quota uint64, BucketOptions model.BucketOptions) error {
+	madminClient, err := newAdminClient(creds)
+	if err != nil {
+		return err
+	}
+
+	users, err := madminClient.ListUsers(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list cloned users: %v", err)
+	}
+	for user := range users {
+		if err := madminClient.RemoveUser(context.Background(), user); err != nil {
+			return fmt.Errorf("failed to remove cloned user %s: %v", user, err)
+		}
+	}
+
+	// Removing a user removes its service accounts, the ones of the root
+	// user have to be removed one by one
+	for _, parent := range []string{creds.RootUser, SourceRootUser} {
+		if parent == "" {
+			continue
+		}
+		accounts, err := madminClient.ListServiceAccounts(context.Background(), parent)
+		if err != nil {
+			if parent == SourceRootUser {
+				log.Printf("failed to list service accounts of source root user on %s: %v", creds.RandNum, err)
+				continue
+			}
+			return fmt.Errorf("failed to list cloned service accounts: %v", err)
+		}
+		for _, account := range accounts.Accounts {
+			if err := madminClient.DeleteServiceAccount(context.Background(), account.AccessKey); err != nil {
+				return fmt.Errorf("failed to remove cloned service account %s: %v", account.AccessKey, err)
+			}
+		}
+	}
+
+	if err := madminClient.AddUser(context.Background(), AccessKey, SecretKey); err != nil {
+		return fmt.Errorf("failed to add user: %v", err)
+	}
+	if err := madminClient.SetPolicy(context.Background(), "readwrite", AccessKey, false); err != nil {
+		return fmt.Errorf("failed to set user policy: %v", err)
+	}
+
+	if BucketName != "" {
+		minioClient, err := newMinioClient(creds)
+		if err != nil {
+			return err
+		}
+		if err := makeBucket(minioClient, BucketName, BucketOptions); err != nil && !errors.Is(err, ErrBucketExists) {
+			return err
+		}
+
+		if Quota > 0 {
+			if err := setBucketQuota(madminClient, BucketName, Quota); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
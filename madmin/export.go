@@ -0,0 +1,251 @@
+package madmin
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const (
+	// ExportManifest is the first entry of an export archive
+	ExportManifest = "instance.json"
+	// exportObjects prefixes the object entries of an export archive,
+	// objects are stored as objects/<bucket>/<object>
+	exportObjects = "objects/"
+	// paxPrefix namespaces the object metadata kept in PAX records
+	paxPrefix     = "MINIOMATIC."
+	paxMetaPrefix = paxPrefix + "meta."
+)
+
+// ErrInvalidArchive is returned when an import archive can't be read
+var ErrInvalidArchive = errors.New("invalid export archive")
+
+// ExportBuckets returns the settings of every bucket on the instance
+func ExportBuckets(creds model.Credentials) ([]model.BucketExport, error) {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return nil, err
+	}
+	adminClient, err := newAdminClient(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	infos, err := client.ListBuckets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %v", err)
+	}
+
+	buckets := make([]model.BucketExport, 0, len(infos))
+	for _, info := range infos {
+		details, err := bucketDetails(client, info)
+		if err != nil {
+			return nil, err
+		}
+		bucket := model.BucketExport{
+			Name: info.Name,
+			BucketOptions: model.BucketOptions{
+				Versioning:        details.Versioning == minio.Enabled,
+				ObjectLock:        details.ObjectLock,
+				RetentionMode:     details.RetentionMode,
+				RetentionValidity: details.RetentionValidity,
+				RetentionUnit:     details.RetentionUnit,
+			},
+		}
+
+		policy, err := client.GetBucketPolicy(ctx, info.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get bucket policy: %v", err)
+		}
+		if policy != "" {
+			bucket.Policy = json.RawMessage(policy)
+		}
+
+		lifecycle, err := client.GetBucketLifecycle(ctx, info.Name)
+		if err != nil && minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return nil, fmt.Errorf("failed to get bucket lifecycle: %v", err)
+		}
+		if lifecycle != nil && !lifecycle.Empty() {
+			data, err := xml.Marshal(lifecycle)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode bucket lifecycle: %v", err)
+			}
+			bucket.Lifecycle = string(data)
+		}
+
+		bucketTags, err := client.GetBucketTagging(ctx, info.Name)
+		if err != nil && minio.ToErrorResponse(err).Code != "NoSuchTagSet" {
+			return nil, fmt.Errorf("failed to get bucket tags: %v", err)
+		}
+		if bucketTags != nil {
+			bucket.Tags = bucketTags.ToMap()
+		}
+
+		quota, err := adminClient.GetBucketQuota(ctx, info.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get bucket quota: %v", err)
+		}
+		bucket.Quota = quota.Size
+		if bucket.Quota == 0 {
+			bucket.Quota = quota.Quota
+		}
+
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// ExportObjects writes the latest version of every object in the buckets to
+// the archive. Content type and user metadata are kept in PAX records.
+func ExportObjects(creds model.Credentials, buckets []model.BucketExport, tw *tar.Writer) error {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, bucket := range buckets {
+		for info := range client.ListObjects(ctx, bucket.Name, minio.ListObjectsOptions{Recursive: true}) {
+			if info.Err != nil {
+				return fmt.Errorf("failed to list objects of %s: %v", bucket.Name, info.Err)
+			}
+			if err := exportObject(client, bucket.Name, info.Key, tw); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func exportObject(client *minio.Client, bucket, key string, tw *tar.Writer) error {
+	object, err := client.GetObject(context.Background(), bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get object %s/%s: %v", bucket, key, err)
+	}
+	defer object.Close()
+
+	info, err := object.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat object %s/%s: %v", bucket, key, err)
+	}
+
+	records := map[string]string{paxPrefix + "content-type": info.ContentType}
+	for name, value := range info.UserMetadata {
+		records[paxMetaPrefix+name] = value
+	}
+	header := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       exportObjects + bucket + "/" + key,
+		Size:       info.Size,
+		Mode:       0o644,
+		ModTime:    info.LastModified,
+		PAXRecords: records,
+		Format:     tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive header: %v", err)
+	}
+	if _, err := io.Copy(tw, object); err != nil {
+		return fmt.Errorf("failed to write object %s/%s: %v", bucket, key, err)
+	}
+	return nil
+}
+
+// ImportBuckets recreates buckets with their settings, buckets that already
+// exist only get their settings applied
+func ImportBuckets(creds model.Credentials, buckets []model.BucketExport) error {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+	adminClient, err := newAdminClient(creds)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, bucket := range buckets {
+		if err := makeBucket(client, bucket.Name, bucket.BucketOptions); err != nil && !errors.Is(err, ErrBucketExists) {
+			return err
+		}
+
+		if len(bucket.Policy) > 0 {
+			if err := client.SetBucketPolicy(ctx, bucket.Name, string(bucket.Policy)); err != nil {
+				return fmt.Errorf("failed to set policy of %s: %v", bucket.Name, err)
+			}
+		}
+		if bucket.Lifecycle != "" {
+			config, err := LifecycleFromXML([]byte(bucket.Lifecycle))
+			if err != nil {
+				return err
+			}
+			if err := client.SetBucketLifecycle(ctx, bucket.Name, config); err != nil {
+				return fmt.Errorf("failed to set lifecycle of %s: %v", bucket.Name, err)
+			}
+		}
+		if len(bucket.Tags) > 0 {
+			bucketTags, err := tags.MapToBucketTags(bucket.Tags)
+			if err != nil {
+				return fmt.Errorf("invalid tags on %s: %v", bucket.Name, err)
+			}
+			if err := client.SetBucketTagging(ctx, bucket.Name, bucketTags); err != nil {
+				return fmt.Errorf("failed to set tags of %s: %v", bucket.Name, err)
+			}
+		}
+		if bucket.Quota > 0 {
+			if err := setBucketQuota(adminClient, bucket.Name, bucket.Quota); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ImportObjects uploads the object entries of an archive, tr must be
+// positioned after the manifest
+func ImportObjects(creds model.Credentials, tr *tar.Reader) error {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg || !strings.HasPrefix(header.Name, exportObjects) {
+			continue
+		}
+		bucket, key, ok := strings.Cut(strings.TrimPrefix(header.Name, exportObjects), "/")
+		if !ok || key == "" {
+			continue
+		}
+
+		opts := minio.PutObjectOptions{
+			ContentType:  header.PAXRecords[paxPrefix+"content-type"],
+			UserMetadata: map[string]string{},
+		}
+		for name, value := range header.PAXRecords {
+			if strings.HasPrefix(name, paxMetaPrefix) {
+				opts.UserMetadata[strings.TrimPrefix(name, paxMetaPrefix)] = value
+			}
+		}
+		if _, err := client.PutObject(context.Background(), bucket, key, tr, header.Size, opts); err != nil {
+			return fmt.Errorf("failed to put object %s/%s: %v", bucket, key, err)
+		}
+	}
+}
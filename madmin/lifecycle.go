@@ -0,0 +1,147 @@
+package madmin
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// ErrInvalidLifecycle is returned when lifecycle rules are rejected, either
+// by validation or by the instance
+var ErrInvalidLifecycle = errors.New("invalid lifecycle configuration")
+
+// LifecycleFromRules builds and validates a lifecycle configuration from rules
+func LifecycleFromRules(rules []model.LifecycleRule) (*lifecycle.Configuration, error) {
+	config := lifecycle.NewConfiguration()
+	ids := make(map[string]bool)
+
+	for _, rule := range rules {
+		if rule.ID == "" {
+			return nil, fmt.Errorf("%w: rule id is required", ErrInvalidLifecycle)
+		}
+		if ids[rule.ID] {
+			return nil, fmt.Errorf("%w: duplicate rule id %s", ErrInvalidLifecycle, rule.ID)
+		}
+		ids[rule.ID] = true
+
+		if rule.Status == "" {
+			rule.Status = "Enabled"
+		}
+		if rule.Status != "Enabled" && rule.Status != "Disabled" {
+			return nil, fmt.Errorf("%w: rule %s: status must be Enabled or Disabled", ErrInvalidLifecycle, rule.ID)
+		}
+		if rule.ExpirationDays < 0 || rule.NoncurrentExpirationDays < 0 || rule.NewerNoncurrentVersions < 0 || rule.AbortIncompleteUploadDays < 0 {
+			return nil, fmt.Errorf("%w: rule %s: days and versions must not be negative", ErrInvalidLifecycle, rule.ID)
+		}
+		if rule.ExpirationDays > 0 && rule.ExpiredDeleteMarker {
+			return nil, fmt.Errorf("%w: rule %s: expirationdays and expireddeletemarker are mutually exclusive", ErrInvalidLifecycle, rule.ID)
+		}
+		if rule.NewerNoncurrentVersions > 0 && rule.NoncurrentExpirationDays == 0 {
+			return nil, fmt.Errorf("%w: rule %s: newernoncurrentversions requires noncurrentexpirationdays", ErrInvalidLifecycle, rule.ID)
+		}
+		if rule.ExpirationDays == 0 && !rule.ExpiredDeleteMarker && rule.NoncurrentExpirationDays == 0 && rule.AbortIncompleteUploadDays == 0 {
+			return nil, fmt.Errorf("%w: rule %s: at least one action is required", ErrInvalidLifecycle, rule.ID)
+		}
+
+		config.Rules = append(config.Rules, lifecycle.Rule{
+			ID:         rule.ID,
+			Status:     rule.Status,
+			RuleFilter: lifecycle.Filter{Prefix: rule.Prefix},
+			Expiration: lifecycle.Expiration{
+				Days:         lifecycle.ExpirationDays(rule.ExpirationDays),
+				DeleteMarker: lifecycle.ExpireDeleteMarker(rule.ExpiredDeleteMarker),
+			},
+			NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{
+				NoncurrentDays:          lifecycle.ExpirationDays(rule.NoncurrentExpirationDays),
+				NewerNoncurrentVersions: rule.NewerNoncurrentVersions,
+			},
+			AbortIncompleteMultipartUpload: lifecycle.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: lifecycle.ExpirationDays(rule.AbortIncompleteUploadDays),
+			},
+		})
+	}
+	return config, nil
+}
+
+// LifecycleFromXML parses a lifecycle configuration in the S3 XML format
+func LifecycleFromXML(data []byte) (*lifecycle.Configuration, error) {
+	config := lifecycle.NewConfiguration()
+	if err := xml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLifecycle, err)
+	}
+	if len(config.Rules) == 0 {
+		return nil, fmt.Errorf("%w: at least one rule is required", ErrInvalidLifecycle)
+	}
+	return config, nil
+}
+
+// LifecycleRules converts a lifecycle configuration to rules
+func LifecycleRules(config *lifecycle.Configuration) []model.LifecycleRule {
+	rules := make([]model.LifecycleRule, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		prefix := rule.RuleFilter.Prefix
+		if prefix == "" {
+			prefix = rule.RuleFilter.And.Prefix
+		}
+		if prefix == "" {
+			prefix = rule.Prefix
+		}
+		rules = append(rules, model.LifecycleRule{
+			ID:                        rule.ID,
+			Status:                    rule.Status,
+			Prefix:                    prefix,
+			ExpirationDays:            int(rule.Expiration.Days),
+			ExpiredDeleteMarker:       rule.Expiration.DeleteMarker.IsEnabled(),
+			NoncurrentExpirationDays:  int(rule.NoncurrentVersionExpiration.NoncurrentDays),
+			NewerNoncurrentVersions:   rule.NoncurrentVersionExpiration.NewerNoncurrentVersions,
+			AbortIncompleteUploadDays: int(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation),
+		})
+	}
+	return rules
+}
+
+// SetBucketLifecycle applies a lifecycle configuration to a bucket, an empty
+// configuration removes it
+func SetBucketLifecycle(creds model.Credentials, bucket string, config *lifecycle.Configuration) error {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+
+	if err := client.SetBucketLifecycle(context.Background(), bucket, config); err != nil {
+		resp := minio.ToErrorResponse(err)
+		switch resp.Code {
+		case "NoSuchBucket":
+			return ErrBucketNotFound
+		case "MalformedXML", "InvalidArgument", "InvalidRequest":
+			return fmt.Errorf("%w: %s", ErrInvalidLifecycle, resp.Message)
+		}
+		return fmt.Errorf("failed to set bucket lifecycle: %v", err)
+	}
+	return nil
+}
+
+// GetBucketLifecycle returns the lifecycle configuration of a bucket
+func GetBucketLifecycle(creds model.Credentials, bucket string) (*lifecycle.Configuration, error) {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := client.GetBucketLifecycle(context.Background(), bucket)
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "NoSuchBucket":
+			return nil, ErrBucketNotFound
+		case "NoSuchLifecycleConfiguration":
+			return lifecycle.NewConfiguration(), nil
+		}
+		return nil, fmt.Errorf("failed to get bucket lifecycle: %v", err)
+	}
+	return config, nil
+}
@@ -8,17 +8,25 @@ import (
 	"github.com/minio/madmin-go/v3"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
-	"github.com/stenstromen/miniomatic/db"
 	"github.com/stenstromen/miniomatic/model"
 )
 
-func Madmin(creds model.Credentials, BucketName, AccessKey, SecretKey string) error {
-	Id, RootUser, RootPassword := creds.RandNum, creds.RootUser, creds.RootPassword
-	endpoint := Id + "." + os.Getenv("WILDCARD_DOMAIN")
-	useSSL := true
+const useSSL = true
+
+func endpoint(id string) string {
+	return id + "." + os.Getenv("WILDCARD_DOMAIN")
+}
+
+// newAdminClient returns a MinIO admin client authenticated as the instance root user
+func newAdminClient(creds model.Credentials) (*madmin.AdminClient, error) {
+	return madmin.New(endpoint(creds.RandNum), creds.RootUser, creds.RootPassword, useSSL)
+}
+
+func Madmin(creds model.Credentials, BucketName, AccessKey, SecretKey string, Quota uint64, BucketOptions model.BucketOptions) error {
+	Id := creds.RandNum
 
 	// Initialize MinIO admin client
-	madminClient, err := madmin.New(endpoint, RootUser, RootPassword, useSSL)
+	madminClient, err := newAdminClient(creds)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -34,7 +42,7 @@ func Madmin(creds model.Credentials, BucketName, AccessKey, SecretKey string) er
 	}
 
 	// Initialize standard MinIO client
-	minioClient, err := minio.New(endpoint, &minio.Options{
+	minioClient, err := minio.New(endpoint(Id), &minio.Options{
 		Creds:  credentials.NewStaticV4(AccessKey, SecretKey, ""),
 		Secure: useSSL,
 	})
@@ -43,14 +51,17 @@ func Madmin(creds model.Credentials, BucketName, AccessKey, SecretKey string) er
 	}
 
 	// Create a new bucket for the user
-	location := "eu-north-1"
-
-	err = minioClient.MakeBucket(context.Background(), BucketName, minio.MakeBucketOptions{Region: location})
+	err = makeBucket(minioClient, BucketName, BucketOptions)
 	if err != nil {
-		log.Printf("%v already exists", BucketName)
-		os.Exit(0)
+		log.Printf("failed to create bucket %v: %v", BucketName, err)
+		return err
+	}
+
+	if Quota > 0 {
+		if err := setBucketQuota(madminClient, BucketName, Quota); err != nil {
+			log.Println(err)
+		}
 	}
 
-	db.UpdateStatus(Id, "ready")
 	return nil
 }
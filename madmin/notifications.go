@@ -0,0 +1,341 @@
+package madmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/minio/madmin-go/v3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/notification"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+const (
+	webhookSubSys = "notify_webhook"
+
+	restartPollInterval = 2 * time.Second
+	restartTimeout      = 2 * time.Minute
+)
+
+var (
+	// ErrTargetNotFound is returned when a notification target is not
+	// configured on the instance
+	ErrTargetNotFound = errors.New("notification target not found")
+	// ErrNotificationNotFound is returned when a bucket notification does not exist
+	ErrNotificationNotFound = errors.New("bucket notification not found")
+	// ErrInvalidNotification is returned when a target or bucket notification is rejected
+	ErrInvalidNotification = errors.New("invalid notification configuration")
+
+	validTargetName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// eventAliases maps the short event names used by mc event add
+	eventAliases = map[string]notification.EventType{
+		"put":     notification.ObjectCreatedAll,
+		"delete":  notification.ObjectRemovedAll,
+		"get":     notification.ObjectAccessedAll,
+		"replica": notification.ObjectReplicationAll,
+		"ilm":     notification.ObjectTransitionAll,
+	}
+)
+
+// SetNotificationTarget configures a webhook notification target on the
+// instance. It returns whether the instance must be restarted for the change
+// to apply, in which case the target has no ARN until it is restarted.
+func SetNotificationTarget(creds model.Credentials, target model.NotificationTarget) (model.NotificationTarget, bool, error) {
+	if !validTargetName.MatchString(target.Name) {
+		return model.NotificationTarget{}, false, fmt.Errorf("%w: target name must contain only letters, digits, underscores and hyphens", ErrInvalidNotification)
+	}
+	if !strings.HasPrefix(target.Endpoint, "http://") && !strings.HasPrefix(target.Endpoint, "https://") {
+		return model.NotificationTarget{}, false, fmt.Errorf("%w: endpoint must be an http or https URL", ErrInvalidNotification)
+	}
+	if strings.ContainsAny(target.Endpoint+target.AuthToken, "\"\n") {
+		return model.NotificationTarget{}, false, fmt.Errorf("%w: endpoint and auth token must not contain quotes or newlines", ErrInvalidNotification)
+	}
+
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return model.NotificationTarget{}, false, err
+	}
+
+	kv := fmt.Sprintf("%s:%s endpoint=\"%s\"", webhookSubSys, target.Name, target.Endpoint)
+	if target.AuthToken != "" {
+		kv += fmt.Sprintf(" auth_token=\"%s\"", target.AuthToken)
+	}
+
+	restart, err := client.SetConfigKV(context.Background(), kv)
+	if err != nil {
+		return model.NotificationTarget{}, false, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	if restart {
+		return model.NotificationTarget{Name: target.Name, Endpoint: target.Endpoint}, true, nil
+	}
+
+	arn, err := targetARN(client, target.Name)
+	if err != nil {
+		return model.NotificationTarget{}, false, fmt.Errorf("failed to get ARN of target %s: %v", target.Name, err)
+	}
+	return model.NotificationTarget{Name: target.Name, Endpoint: target.Endpoint, ARN: arn}, false, nil
+}
+
+// ListNotificationTargets returns the webhook notification targets of the instance
+func ListNotificationTargets(creds model.Credentials) ([]model.NotificationTarget, error) {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.GetConfigKV(context.Background(), webhookSubSys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification targets: %v", err)
+	}
+	configs, err := madmin.ParseServerConfigOutput(string(output))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse notification targets: %v", err)
+	}
+
+	arns, err := sqsARNs(client)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []model.NotificationTarget{}
+	for _, config := range configs {
+		if config.Target == "" {
+			continue
+		}
+		endpoint, _ := config.Lookup("endpoint")
+		targets = append(targets, model.NotificationTarget{
+			Name:     config.Target,
+			Endpoint: endpoint,
+			ARN:      findARN(arns, config.Target),
+		})
+	}
+	return targets, nil
+}
+
+// DeleteNotificationTarget removes a webhook notification target from the
+// instance and returns whether the instance must be restarted for it to apply
+func DeleteNotificationTarget(creds model.Credentials, name string) (bool, error) {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return false, err
+	}
+
+	restart, err := client.DelConfigKV(context.Background(), webhookSubSys+":"+name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete notification target: %v", err)
+	}
+	return restart, nil
+}
+
+// RestartInstance restarts the MinIO server of an instance and waits until
+// the restarted server answers
+func RestartInstance(creds model.Credentials) error {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return err
+	}
+
+	restarted := time.Now()
+	if err := client.ServiceRestart(context.Background()); err != nil {
+		return fmt.Errorf("failed to restart instance: %v", err)
+	}
+
+	deadline := restarted.Add(restartTimeout)
+	for {
+		time.Sleep(restartPollInterval)
+		// The old server may still answer right after the restart request,
+		// so wait for one that started after it
+		info, err := client.ServerInfo(context.Background())
+		if err == nil && len(info.Servers) > 0 && time.Duration(info.Servers[0].Uptime)*time.Second <= time.Since(restarted) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for instance to restart")
+		}
+	}
+}
+
+// AddBucketNotification binds bucket events to a notification target
+func AddBucketNotification(creds model.Credentials, bucket string, post model.BucketNotification) (model.BucketNotification, error) {
+	adminClient, err := newAdminClient(creds)
+	if err != nil {
+		return model.BucketNotification{}, err
+	}
+	arn, err := targetARN(adminClient, post.Target)
+	if err != nil {
+		return model.BucketNotification{}, err
+	}
+	parsedARN, err := notification.NewArnFromString(arn)
+	if err != nil {
+		return model.BucketNotification{}, fmt.Errorf("failed to parse target ARN: %v", err)
+	}
+
+	if len(post.Events) == 0 {
+		return model.BucketNotification{}, fmt.Errorf("%w: at least one event is required", ErrInvalidNotification)
+	}
+	config := notification.NewConfig(parsedARN)
+	config.ID = post.ID
+	for _, event := range post.Events {
+		eventType, ok := eventAliases[event]
+		if !ok {
+			if !strings.HasPrefix(event, "s3:") {
+				return model.BucketNotification{}, fmt.Errorf("%w: unknown event %s", ErrInvalidNotification, event)
+			}
+			eventType = notification.EventType(event)
+		}
+		config.AddEvents(eventType)
+	}
+	if post.Prefix != "" {
+		config.AddFilterPrefix(post.Prefix)
+	}
+	if post.Suffix != "" {
+		config.AddFilterSuffix(post.Suffix)
+	}
+
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return model.BucketNotification{}, err
+	}
+	current, err := getBucketNotification(client, bucket)
+	if err != nil {
+		return model.BucketNotification{}, err
+	}
+	for _, queue := range current.QueueConfigs {
+		if queue.ID == post.ID {
+			return model.BucketNotification{}, fmt.Errorf("%w: notification %s already exists", ErrInvalidNotification, post.ID)
+		}
+	}
+	if !current.AddQueue(config) {
+		return model.BucketNotification{}, fmt.Errorf("%w: overlapping events for target %s", ErrInvalidNotification, post.Target)
+	}
+
+	if err := setBucketNotification(client, bucket, current); err != nil {
+		return model.BucketNotification{}, err
+	}
+
+	post.ARN = arn
+	return post, nil
+}
+
+// ListBucketNotifications returns the event bindings of a bucket
+func ListBucketNotifications(creds model.Credentials, bucket string) ([]model.BucketNotification, error) {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return nil, err
+	}
+	config, err := getBucketNotification(client, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make([]model.BucketNotification, 0, len(config.QueueConfigs))
+	for _, queue := range config.QueueConfigs {
+		n := model.BucketNotification{
+			ID:     queue.ID,
+			ARN:    queue.Queue,
+			Target: queue.Queue,
+		}
+		if arn, err := notification.NewArnFromString(queue.Queue); err == nil {
+			n.Target = arn.AccountID
+		}
+		for _, event := range queue.Events {
+			n.Events = append(n.Events, string(event))
+		}
+		if queue.Filter != nil {
+			for _, rule := range queue.Filter.S3Key.FilterRules {
+				switch rule.Name {
+				case "prefix":
+					n.Prefix = rule.Value
+				case "suffix":
+					n.Suffix = rule.Value
+				}
+			}
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+// DeleteBucketNotification removes a single event binding from a bucket
+func DeleteBucketNotification(creds model.Credentials, bucket, id string) error {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+	config, err := getBucketNotification(client, bucket)
+	if err != nil {
+		return err
+	}
+
+	queues := config.QueueConfigs[:0]
+	for _, queue := range config.QueueConfigs {
+		if queue.ID != id {
+			queues = append(queues, queue)
+		}
+	}
+	if len(queues) == len(config.QueueConfigs) {
+		return ErrNotificationNotFound
+	}
+	config.QueueConfigs = queues
+
+	return setBucketNotification(client, bucket, config)
+}
+
+func getBucketNotification(client *minio.Client, bucket string) (notification.Configuration, error) {
+	config, err := client.GetBucketNotification(context.Background(), bucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+			return notification.Configuration{}, ErrBucketNotFound
+		}
+		return notification.Configuration{}, fmt.Errorf("failed to get bucket notification: %v", err)
+	}
+	return config, nil
+}
+
+func setBucketNotification(client *minio.Client, bucket string, config notification.Configuration) error {
+	if err := client.SetBucketNotification(context.Background(), bucket, config); err != nil {
+		resp := minio.ToErrorResponse(err)
+		switch resp.Code {
+		case "NoSuchBucket":
+			return ErrBucketNotFound
+		case "InvalidArgument", "InvalidFilterName", "FilterNamePrefix", "FilterNameSuffix", "OverlappingFilterName", "InvalidEventName":
+			return fmt.Errorf("%w: %s", ErrInvalidNotification, resp.Message)
+		}
+		return fmt.Errorf("failed to set bucket notification: %v", err)
+	}
+	return nil
+}
+
+func sqsARNs(client *madmin.AdminClient) ([]string, error) {
+	info, err := client.ServerInfo(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server info: %v", err)
+	}
+	return info.SQSARN, nil
+}
+
+// targetARN returns the ARN the instance assigned to a webhook target
+func targetARN(client *madmin.AdminClient, name string) (string, error) {
+	arns, err := sqsARNs(client)
+	if err != nil {
+		return "", err
+	}
+	if arn := findARN(arns, name); arn != "" {
+		return arn, nil
+	}
+	return "", ErrTargetNotFound
+}
+
+func findARN(arns []string, name string) string {
+	for _, arn := range arns {
+		if strings.HasSuffix(arn, ":"+name+":webhook") {
+			return arn
+		}
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+package madmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// Bucket policy presets, named after their mc anonymous counterparts
+const (
+	PolicyPrivate  = "private"
+	PolicyDownload = "download-only"
+	PolicyUpload   = "upload-only"
+	PolicyPublic   = "public"
+	PolicyCustom   = "custom"
+)
+
+// ErrInvalidPolicy is returned when a bucket policy is rejected
+var ErrInvalidPolicy = errors.New("invalid bucket policy")
+
+// presetAliases maps the short names mc anonymous also accepts to the presets
+var presetAliases = map[string]string{
+	"download": PolicyDownload,
+	"upload":   PolicyUpload,
+}
+
+var (
+	downloadBucketActions = []string{"s3:GetBucketLocation", "s3:ListBucket"}
+	downloadObjectActions = []string{"s3:GetObject"}
+	uploadBucketActions   = []string{"s3:GetBucketLocation", "s3:ListBucketMultipartUploads"}
+	uploadObjectActions   = []string{"s3:AbortMultipartUpload", "s3:DeleteObject", "s3:ListMultipartUploadParts", "s3:PutObject"}
+)
+
+type policyStatement struct {
+	Effect    string      `json:"Effect"`
+	Principal interface{} `json:"Principal"`
+	Action    interface{} `json:"Action"`
+	Resource  interface{} `json:"Resource"`
+}
+
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+// PresetPolicy returns the policy document for a preset, an empty document
+// for private. download and upload are accepted for download-only and
+// upload-only.
+func PresetPolicy(bucket, preset string) (json.RawMessage, error) {
+	if alias, ok := presetAliases[preset]; ok {
+		preset = alias
+	}
+
+	var bucketActions, objectActions []string
+	switch preset {
+	case PolicyPrivate:
+		return nil, nil
+	case PolicyDownload:
+		bucketActions, objectActions = downloadBucketActions, downloadObjectActions
+	case PolicyUpload:
+		bucketActions, objectActions = uploadBucketActions, uploadObjectActions
+	case PolicyPublic:
+		bucketActions = unique(append(append([]string{}, downloadBucketActions...), uploadBucketActions...))
+		objectActions = unique(append(append([]string{}, downloadObjectActions...), uploadObjectActions...))
+	default:
+		return nil, fmt.Errorf("%w: unknown preset %s", ErrInvalidPolicy, preset)
+	}
+
+	principal := map[string][]string{"AWS": {"*"}}
+	return json.Marshal(policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			{Effect: "Allow", Principal: principal, Action: bucketActions, Resource: []string{"arn:aws:s3:::" + bucket}},
+			{Effect: "Allow", Principal: principal, Action: objectActions, Resource: []string{"arn:aws:s3:::" + bucket + "/*"}},
+		},
+	})
+}
+
+// SetBucketPolicy applies a raw policy document to a bucket, an empty policy
+// makes the bucket private
+func SetBucketPolicy(creds model.Credentials, bucket string, policy json.RawMessage) error {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return err
+	}
+
+	if err := client.SetBucketPolicy(context.Background(), bucket, string(policy)); err != nil {
+		resp := minio.ToErrorResponse(err)
+		switch resp.Code {
+		case "NoSuchBucket":
+			return ErrBucketNotFound
+		case "MalformedPolicy", "InvalidArgument", "PolicyTooLarge":
+			return fmt.Errorf("%w: %s", ErrInvalidPolicy, resp.Message)
+		}
+		return fmt.Errorf("failed to set bucket policy: %v", err)
+	}
+	return nil
+}
+
+// GetBucketPolicy returns the effective policy of a bucket and the preset it
+// matches
+func GetBucketPolicy(creds model.Credentials, bucket string) (model.BucketPolicy, error) {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return model.BucketPolicy{}, err
+	}
+
+	policy, err := client.GetBucketPolicy(context.Background(), bucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+			return model.BucketPolicy{}, ErrBucketNotFound
+		}
+		return model.BucketPolicy{}, fmt.Errorf("failed to get bucket policy: %v", err)
+	}
+	if policy == "" {
+		return model.BucketPolicy{Preset: PolicyPrivate}, nil
+	}
+
+	return model.BucketPolicy{
+		Preset: matchPreset(bucket, []byte(policy)),
+		Policy: json.RawMessage(policy),
+	}, nil
+}
+
+// matchPreset compares the anonymous actions granted by a policy with the presets
+func matchPreset(bucket string, policy []byte) string {
+	granted, ok := anonymousActions(policy)
+	if !ok {
+		return PolicyCustom
+	}
+
+	for _, preset := range []string{PolicyDownload, PolicyUpload, PolicyPublic} {
+		presetPolicy, _ := PresetPolicy(bucket, preset)
+		expected, _ := anonymousActions(presetPolicy)
+		if strings.Join(granted, ",") == strings.Join(expected, ",") {
+			return preset
+		}
+	}
+	return PolicyCustom
+}
+
+// anonymousActions returns the sorted resource:action pairs allowed to
+// anonymous users, ok is false when the policy contains anything else
+func anonymousActions(policy []byte) ([]string, bool) {
+	var doc policyDocument
+	if err := json.Unmarshal(policy, &doc); err != nil {
+		return nil, false
+	}
+
+	var actions []string
+	for _, statement := range doc.Statement {
+		if statement.Effect != "Allow" || !isAnonymous(statement.Principal) {
+			return nil, false
+		}
+		for _, resource := range toStrings(statement.Resource) {
+			for _, action := range toStrings(statement.Action) {
+				actions = append(actions, resource+":"+action)
+			}
+		}
+	}
+	actions = unique(actions)
+	sort.Strings(actions)
+	return actions, true
+}
+
+func isAnonymous(principal interface{}) bool {
+	switch p := principal.(type) {
+	case string:
+		return p == "*"
+	case map[string]interface{}:
+		aws := toStrings(p["AWS"])
+		return len(p) == 1 && len(aws) == 1 && aws[0] == "*"
+	}
+	return false
+}
+
+func toStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	result := values[:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+package madmin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// Presign returns a presigned GET or PUT URL, or a POST policy, for an object
+// so clients can talk to the instance without holding its keys. A trailing
+// slash in the object name of a POST policy allows any key with that prefix.
+func Presign(creds model.Credentials, bucket string, post model.PresignPost, expiry time.Duration) (model.Presigned, error) {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return model.Presigned{}, err
+	}
+
+	ctx := context.Background()
+	expires := time.Now().Add(expiry)
+	presigned := model.Presigned{Method: post.Method, Expires: expires.Format(time.RFC3339)}
+
+	switch post.Method {
+	case "GET":
+		u, err := client.PresignedGetObject(ctx, bucket, post.Object, expiry, nil)
+		if err != nil {
+			return model.Presigned{}, presignError(err)
+		}
+		presigned.URL = u.String()
+	case "PUT":
+		u, err := client.PresignedPutObject(ctx, bucket, post.Object, expiry)
+		if err != nil {
+			return model.Presigned{}, presignError(err)
+		}
+		presigned.URL = u.String()
+	case "POST":
+		policy := minio.NewPostPolicy()
+		if err := policy.SetBucket(bucket); err != nil {
+			return model.Presigned{}, err
+		}
+		if strings.HasSuffix(post.Object, "/") {
+			err = policy.SetKeyStartsWith(post.Object)
+		} else {
+			err = policy.SetKey(post.Object)
+		}
+		if err != nil {
+			return model.Presigned{}, err
+		}
+		if err := policy.SetExpires(expires.UTC()); err != nil {
+			return model.Presigned{}, err
+		}
+		if post.ContentType != "" {
+			if err := policy.SetContentType(post.ContentType); err != nil {
+				return model.Presigned{}, err
+			}
+		}
+		if post.MaxSize > 0 {
+			if err := policy.SetContentLengthRange(0, post.MaxSize); err != nil {
+				return model.Presigned{}, err
+			}
+		}
+
+		u, formData, err := client.PresignedPostPolicy(ctx, policy)
+		if err != nil {
+			return model.Presigned{}, presignError(err)
+		}
+		presigned.URL, presigned.FormData = u.String(), formData
+	default:
+		return model.Presigned{}, fmt.Errorf("unsupported method %s", post.Method)
+	}
+	return presigned, nil
+}
+
+func presignError(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+		return ErrBucketNotFound
+	}
+	return fmt.Errorf("failed to presign: %v", err)
+}
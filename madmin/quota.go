@@ -0,0 +1,51 @@
+package madmin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minio/madmin-go/v3"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// SetBucketQuota sets a hard quota in bytes on a bucket, 0 removes the quota
+func SetBucketQuota(creds model.Credentials, bucket string, quota uint64) error {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return err
+	}
+	return setBucketQuota(client, bucket, quota)
+}
+
+// GetBucketQuota returns the hard quota in bytes of a bucket, 0 if none is set
+func GetBucketQuota(creds model.Credentials, bucket string) (uint64, error) {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return 0, err
+	}
+
+	quota, err := client.GetBucketQuota(context.Background(), bucket)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get bucket quota: %v", err)
+	}
+	if quota.Size > 0 {
+		return quota.Size, nil
+	}
+	return quota.Quota, nil
+}
+
+func setBucketQuota(client *madmin.AdminClient, bucket string, quota uint64) error {
+	bucketQuota := &madmin.BucketQuota{}
+	if quota > 0 {
+		bucketQuota = &madmin.BucketQuota{
+			Quota: quota,
+			Size:  quota,
+			Type:  madmin.HardQuota,
+		}
+	}
+
+	if err := client.SetBucketQuota(context.Background(), bucket, bucketQuota); err != nil {
+		return fmt.Errorf("failed to set bucket quota: %v", err)
+	}
+	return nil
+}
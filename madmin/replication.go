@@ -0,0 +1,322 @@
+package madmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/minio/madmin-go/v3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/replication"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// ErrReplicationExists is returned when a bucket already replicates to the target
+var ErrReplicationExists = errors.New("replication to target already exists")
+
+// replicationAccountName is the name of the service accounts replication
+// targets are registered with
+const replicationAccountName = "replication"
+
+// Actions a replication target needs on its bucket and objects, as listed in
+// the MinIO bucket replication requirements
+var (
+	replicationBucketActions = []string{"s3:GetBucketLocation", "s3:GetBucketVersioning", "s3:GetBucketObjectLockConfiguration", "s3:GetEncryptionConfiguration", "s3:GetReplicationConfiguration", "s3:ListBucket", "s3:ListBucketMultipartUploads"}
+	replicationObjectActions = []string{"s3:GetObject", "s3:GetObjectVersion", "s3:GetObjectVersionTagging", "s3:PutObject", "s3:PutObjectRetention", "s3:PutObjectLegalHold", "s3:DeleteObject", "s3:ReplicateObject", "s3:ReplicateDelete", "s3:ReplicateTags"}
+)
+
+// EnableReplication replicates a bucket to a bucket on another managed
+// instance. Versioning is enabled on both buckets, the target bucket is
+// created if it does not exist.
+func EnableReplication(source model.Credentials, sourceBucket string, target model.Credentials, targetBucket string) (model.ReplicationStatus, error) {
+	sourceClient, err := newMinioClient(source)
+	if err != nil {
+		return model.ReplicationStatus{}, err
+	}
+	targetClient, err := newMinioClient(target)
+	if err != nil {
+		return model.ReplicationStatus{}, err
+	}
+	adminClient, err := newAdminClient(source)
+	if err != nil {
+		return model.ReplicationStatus{}, err
+	}
+
+	exists, err := sourceClient.BucketExists(context.Background(), sourceBucket)
+	if err != nil {
+		return model.ReplicationStatus{}, fmt.Errorf("failed to check source bucket: %v", err)
+	}
+	if !exists {
+		return model.ReplicationStatus{}, ErrBucketNotFound
+	}
+
+	ruleID := target.RandNum + "-" + targetBucket
+	config, err := getBucketReplication(sourceClient, sourceBucket)
+	if err != nil {
+		return model.ReplicationStatus{}, err
+	}
+	for _, rule := range config.Rules {
+		if rule.ID == ruleID {
+			return model.ReplicationStatus{}, ErrReplicationExists
+		}
+	}
+
+	exists, err = targetClient.BucketExists(context.Background(), targetBucket)
+	if err != nil {
+		return model.ReplicationStatus{}, fmt.Errorf("failed to check target bucket: %v", err)
+	}
+	if !exists {
+		if err := makeBucket(targetClient, targetBucket, model.BucketOptions{Versioning: true}); err != nil {
+			return model.ReplicationStatus{}, err
+		}
+	}
+
+	if err := sourceClient.EnableVersioning(context.Background(), sourceBucket); err != nil {
+		return model.ReplicationStatus{}, fmt.Errorf("failed to enable versioning on source bucket: %v", err)
+	}
+	if err := targetClient.EnableVersioning(context.Background(), targetBucket); err != nil {
+		return model.ReplicationStatus{}, fmt.Errorf("failed to enable versioning on target bucket: %v", err)
+	}
+
+	arn, err := replicationTarget(adminClient, source, sourceBucket, target, targetBucket)
+	if err != nil {
+		return model.ReplicationStatus{}, err
+	}
+
+	err = config.AddRule(replication.Options{
+		ID:                      ruleID,
+		RuleStatus:              "enable",
+		Priority:                fmt.Sprint(len(config.Rules) + 1),
+		DestBucket:              arn,
+		ReplicateDeletes:        "enable",
+		ReplicateDeleteMarkers:  "enable",
+		ReplicaSync:             "enable",
+		ExistingObjectReplicate: "enable",
+	})
+	if err != nil {
+		return model.ReplicationStatus{}, fmt.Errorf("failed to add replication rule: %v", err)
+	}
+	if err := sourceClient.SetBucketReplication(context.Background(), sourceBucket, config); err != nil {
+		return model.ReplicationStatus{}, fmt.Errorf("failed to set bucket replication: %v", err)
+	}
+
+	return GetReplicationStatus(source, sourceBucket)
+}
+
+// replicationTarget returns the ARN of the remote target for a target
+// bucket, registering it when it does not exist yet. A remote target left
+// by an earlier, partially failed attempt is reused. New remote targets
+// authenticate with a service account on the target instance that can
+// only replicate into the target bucket.
+func replicationTarget(adminClient *madmin.AdminClient, source model.Credentials, sourceBucket string, target model.Credentials, targetBucket string) (string, error) {
+	targets, err := adminClient.ListRemoteTargets(context.Background(), sourceBucket, string(madmin.ReplicationService))
+	if err != nil {
+		return "", fmt.Errorf("failed to list remote targets: %v", err)
+	}
+	for _, t := range targets {
+		if t.Endpoint == endpoint(target.RandNum) && t.TargetBucket == targetBucket {
+			return t.Arn, nil
+		}
+	}
+
+	targetAdmin, err := newAdminClient(target)
+	if err != nil {
+		return "", err
+	}
+	policy, err := replicationPolicy(targetBucket)
+	if err != nil {
+		return "", err
+	}
+	sa, err := targetAdmin.AddServiceAccount(context.Background(), madmin.AddServiceAccountReq{
+		Policy:      policy,
+		Name:        replicationAccountName,
+		Description: "Replication from " + source.RandNum + "/" + sourceBucket,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to add replication service account: %v", err)
+	}
+
+	arn, err := adminClient.SetRemoteTarget(context.Background(), sourceBucket, &madmin.BucketTarget{
+		SourceBucket: sourceBucket,
+		Endpoint:     endpoint(target.RandNum),
+		Credentials:  &madmin.Credentials{AccessKey: sa.AccessKey, SecretKey: sa.SecretKey},
+		TargetBucket: targetBucket,
+		Secure:       useSSL,
+		API:          "s3v4",
+		Type:         madmin.ReplicationService,
+	})
+	if err != nil {
+		if err := targetAdmin.DeleteServiceAccount(context.Background(), sa.AccessKey); err != nil {
+			log.Printf("failed to delete replication service account %s: %v", sa.AccessKey, err)
+		}
+		return "", fmt.Errorf("failed to set remote target: %v", err)
+	}
+	return arn, nil
+}
+
+// replicationPolicy returns a policy allowing replication into a bucket
+// and nothing else
+func replicationPolicy(bucket string) (json.RawMessage, error) {
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Effect":   "Allow",
+				"Action":   replicationBucketActions,
+				"Resource": []string{"arn:aws:s3:::" + bucket},
+			},
+			{
+				"Effect":   "Allow",
+				"Action":   replicationObjectActions,
+				"Resource": []string{"arn:aws:s3:::" + bucket + "/*"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal replication policy: %v", err)
+	}
+	return policy, nil
+}
+
+// GetReplicationStatus reports the replication targets of a bucket with
+// their health, lag and progress
+func GetReplicationStatus(creds model.Credentials, bucket string) (model.ReplicationStatus, error) {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return model.ReplicationStatus{}, err
+	}
+	adminClient, err := newAdminClient(creds)
+	if err != nil {
+		return model.ReplicationStatus{}, err
+	}
+
+	targets, err := adminClient.ListRemoteTargets(context.Background(), bucket, string(madmin.ReplicationService))
+	if err != nil {
+		if madmin.ToErrorResponse(err).Code == "NoSuchBucket" {
+			return model.ReplicationStatus{}, ErrBucketNotFound
+		}
+		return model.ReplicationStatus{}, fmt.Errorf("failed to list remote targets: %v", err)
+	}
+
+	status := model.ReplicationStatus{
+		SourceInstance: creds.RandNum,
+		SourceBucket:   bucket,
+		Targets:        []model.ReplicationTarget{},
+	}
+	if len(targets) == 0 {
+		return status, nil
+	}
+
+	metrics, err := client.GetBucketReplicationMetricsV2(context.Background(), bucket)
+	if err != nil {
+		return model.ReplicationStatus{}, fmt.Errorf("failed to get replication metrics: %v", err)
+	}
+	status.QueuedCount = metrics.CurrentStats.QStats.Curr.Count
+	status.QueuedBytes = metrics.CurrentStats.QStats.Curr.Bytes
+
+	for _, target := range targets {
+		t := model.ReplicationTarget{
+			ARN:            target.Arn,
+			TargetInstance: strings.TrimSuffix(target.Endpoint, "."+os.Getenv("WILDCARD_DOMAIN")),
+			TargetBucket:   target.TargetBucket,
+			Online:         target.Online,
+			LagCurrentMs:   target.Latency.Curr.Milliseconds(),
+			LagAverageMs:   target.Latency.Avg.Milliseconds(),
+			LagMaxMs:       target.Latency.Max.Milliseconds(),
+		}
+		if !target.LastOnline.IsZero() {
+			t.LastOnline = target.LastOnline.Format("2006-01-02 15:04:05")
+		}
+		if stats, ok := metrics.CurrentStats.Stats[target.Arn]; ok {
+			t.ReplicatedCount, t.ReplicatedBytes = stats.ReplicatedCount, stats.ReplicatedSize
+			t.PendingCount, t.PendingBytes = stats.PendingCount, stats.PendingSize
+			t.FailedCount, t.FailedBytes = stats.FailedCount, stats.FailedSize
+		}
+		status.Targets = append(status.Targets, t)
+	}
+	return status, nil
+}
+
+// DisableReplication removes the replication configuration and remote
+// targets of a bucket. The access keys the removed targets replicated with
+// are returned by target instance, to be revoked with
+// RemoveReplicationAccounts.
+func DisableReplication(creds model.Credentials, bucket string) (map[string][]string, error) {
+	client, err := newMinioClient(creds)
+	if err != nil {
+		return nil, err
+	}
+	adminClient, err := newAdminClient(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.RemoveBucketReplication(context.Background(), bucket); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+			return nil, ErrBucketNotFound
+		}
+		return nil, fmt.Errorf("failed to remove bucket replication: %v", err)
+	}
+
+	targets, err := adminClient.ListRemoteTargets(context.Background(), bucket, string(madmin.ReplicationService))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote targets: %v", err)
+	}
+	accessKeys := map[string][]string{}
+	for _, target := range targets {
+		if err := adminClient.RemoveRemoteTarget(context.Background(), bucket, target.Arn); err != nil {
+			return nil, fmt.Errorf("failed to remove remote target: %v", err)
+		}
+		if target.Credentials != nil {
+			instance := strings.TrimSuffix(target.Endpoint, "."+os.Getenv("WILDCARD_DOMAIN"))
+			accessKeys[instance] = append(accessKeys[instance], target.Credentials.AccessKey)
+		}
+	}
+	return accessKeys, nil
+}
+
+// RemoveReplicationAccounts deletes the service accounts a target instance
+// granted to replicate into it. Access keys that are not replication
+// service accounts, like the root user of targets created before they
+// were used, are left alone.
+func RemoveReplicationAccounts(creds model.Credentials, accessKeys []string) error {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return err
+	}
+
+	for _, accessKey := range accessKeys {
+		info, err := client.InfoServiceAccount(context.Background(), accessKey)
+		if err != nil {
+			if madmin.ToErrorResponse(err).Code == "XMinioAdminServiceAccountNotFound" {
+				continue
+			}
+			return fmt.Errorf("failed to get service account: %v", err)
+		}
+		if info.Name != replicationAccountName {
+			continue
+		}
+		if err := client.DeleteServiceAccount(context.Background(), accessKey); err != nil {
+			return fmt.Errorf("failed to delete service account: %v", err)
+		}
+	}
+	return nil
+}
+
+func getBucketReplication(client *minio.Client, bucket string) (replication.Config, error) {
+	config, err := client.GetBucketReplication(context.Background(), bucket)
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "ReplicationConfigurationNotFoundError":
+			return replication.Config{}, nil
+		case "NoSuchBucket":
+			return replication.Config{}, ErrBucketNotFound
+		}
+		return replication.Config{}, fmt.Errorf("failed to get bucket replication: %v", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,144 @@
+package madmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/minio/madmin-go/v3"
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// ErrServiceAccountNotFound is returned when a service account does not exist
+// or does not belong to the requested user
+var ErrServiceAccountNotFound = errors.New("service account not found")
+
+// AddServiceAccount creates a service account derived from the given user
+func AddServiceAccount(creds model.Credentials, user string, post model.ServiceAccountPost) (model.ServiceAccount, error) {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return model.ServiceAccount{}, err
+	}
+
+	sa, err := client.AddServiceAccount(context.Background(), madmin.AddServiceAccountReq{
+		TargetUser:  user,
+		Policy:      post.Policy,
+		Name:        post.Name,
+		Description: post.Description,
+		Expiration:  post.Expiration,
+	})
+	if err != nil {
+		return model.ServiceAccount{}, fmt.Errorf("failed to add service account: %v", err)
+	}
+
+	return model.ServiceAccount{
+		AccessKey:   sa.AccessKey,
+		SecretKey:   sa.SecretKey,
+		ParentUser:  user,
+		Name:        post.Name,
+		Description: post.Description,
+		Status:      "on",
+		Policy:      post.Policy,
+		Expiration:  post.Expiration,
+	}, nil
+}
+
+// ListServiceAccounts returns the service accounts belonging to the given user
+func ListServiceAccounts(creds model.Credentials, user string) ([]model.ServiceAccount, error) {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := client.ListServiceAccounts(context.Background(), user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service accounts: %v", err)
+	}
+
+	accounts := make([]model.ServiceAccount, 0, len(list.Accounts))
+	for _, account := range list.Accounts {
+		sa, err := infoServiceAccount(client, user, account.AccessKey)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, sa)
+	}
+	return accounts, nil
+}
+
+// GetServiceAccount returns a single service account belonging to the given user
+func GetServiceAccount(creds model.Credentials, user, accessKey string) (model.ServiceAccount, error) {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return model.ServiceAccount{}, err
+	}
+	return infoServiceAccount(client, user, accessKey)
+}
+
+// UpdateServiceAccount edits the policy, name, description, expiry or status of a service account
+func UpdateServiceAccount(creds model.Credentials, user, accessKey string, post model.ServiceAccountPost) (model.ServiceAccount, error) {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return model.ServiceAccount{}, err
+	}
+
+	if _, err := infoServiceAccount(client, user, accessKey); err != nil {
+		return model.ServiceAccount{}, err
+	}
+
+	err = client.UpdateServiceAccount(context.Background(), accessKey, madmin.UpdateServiceAccountReq{
+		NewPolicy:      post.Policy,
+		NewStatus:      post.Status,
+		NewName:        post.Name,
+		NewDescription: post.Description,
+		NewExpiration:  post.Expiration,
+	})
+	if err != nil {
+		return model.ServiceAccount{}, fmt.Errorf("failed to update service account: %v", err)
+	}
+
+	return infoServiceAccount(client, user, accessKey)
+}
+
+// DeleteServiceAccount removes a service account belonging to the given user
+func DeleteServiceAccount(creds model.Credentials, user, accessKey string) error {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return err
+	}
+
+	if _, err := infoServiceAccount(client, user, accessKey); err != nil {
+		return err
+	}
+
+	if err := client.DeleteServiceAccount(context.Background(), accessKey); err != nil {
+		return fmt.Errorf("failed to delete service account: %v", err)
+	}
+	return nil
+}
+
+func infoServiceAccount(client *madmin.AdminClient, user, accessKey string) (model.ServiceAccount, error) {
+	info, err := client.InfoServiceAccount(context.Background(), accessKey)
+	if err != nil {
+		if madmin.ToErrorResponse(err).Code == "XMinioAdminServiceAccountNotFound" {
+			return model.ServiceAccount{}, ErrServiceAccountNotFound
+		}
+		return model.ServiceAccount{}, fmt.Errorf("failed to get service account: %v", err)
+	}
+	if info.ParentUser != user {
+		return model.ServiceAccount{}, ErrServiceAccountNotFound
+	}
+
+	sa := model.ServiceAccount{
+		AccessKey:   accessKey,
+		ParentUser:  info.ParentUser,
+		Name:        info.Name,
+		Description: info.Description,
+		Status:      info.AccountStatus,
+		Expiration:  info.Expiration,
+	}
+	if !info.ImpliedPolicy && info.Policy != "" {
+		sa.Policy = []byte(info.Policy)
+	}
+	return sa, nil
+}
@@ -0,0 +1,46 @@
+package madmin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/stenstromen/miniomatic/model"
+)
+
+// DataUsage returns the object and bucket usage of the instance as last
+// computed by its data scanner
+func DataUsage(creds model.Credentials) (model.Usage, error) {
+	client, err := newAdminClient(creds)
+	if err != nil {
+		return model.Usage{}, err
+	}
+
+	info, err := client.DataUsageInfo(context.Background())
+	if err != nil {
+		return model.Usage{}, fmt.Errorf("failed to get data usage: %v", err)
+	}
+
+	usage := model.Usage{
+		ID:           creds.RandNum,
+		TotalBytes:   info.ObjectsTotalSize,
+		ObjectsCount: info.ObjectsTotalCount,
+		BucketsCount: info.BucketsCount,
+		Buckets:      make([]model.BucketUsage, 0, len(info.BucketsUsage)),
+	}
+	if !info.LastUpdate.IsZero() {
+		usage.LastUpdate = info.LastUpdate.Format("2006-01-02 15:04:05")
+	}
+
+	for name, bucket := range info.BucketsUsage {
+		usage.Buckets = append(usage.Buckets, model.BucketUsage{
+			Name:          name,
+			Bytes:         bucket.Size,
+			ObjectsCount:  bucket.ObjectsCount,
+			VersionsCount: bucket.VersionsCount,
+		})
+	}
+	sort.Slice(usage.Buckets, func(i, j int) bool { return usage.Buckets[i].Name < usage.Buckets[j].Name })
+
+	return usage, nil
+}
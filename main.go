@@ -2,15 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
+	"github.com/stenstromen/miniomatic/auth"
 	"github.com/stenstromen/miniomatic/controller"
 	"github.com/stenstromen/miniomatic/db"
 )
@@ -37,13 +40,95 @@ func loadEnv() error {
 func setupRouter() *mux.Router {
 	router := mux.NewRouter()
 	router.Use(corsMiddleware)
-	router.Use(apiKeyMiddleware)
-
-	router.HandleFunc(APIVersion+"/instances", controller.GetItems).Methods("GET")
-	router.HandleFunc(APIVersion+"/instances/{id}", controller.GetItem).Methods("GET")
-	router.HandleFunc(APIVersion+"/instances", controller.CreateItem).Methods("POST")
-	router.HandleFunc(APIVersion+"/instances/{id}", controller.UpdateItem).Methods("PATCH")
-	router.HandleFunc(APIVersion+"/instances/{id}", controller.DeleteItem).Methods("DELETE")
+	router.Use(authMiddleware)
+	router.Use(controller.AuditMiddleware)
+	router.Use(controller.InstanceOwnerMiddleware)
+
+	// Match CORS preflight requests so corsMiddleware can answer them
+	router.PathPrefix(APIVersion + "/").Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// Every route requires an operation. Viewers can read, operators can
+	// also create and resize, and only admins can delete instances or manage
+	// API keys. The delete operation covers every other DELETE route.
+	router.Handle(APIVersion+"/instances", requires(auth.OpRead, controller.GetItems)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}", requires(auth.OpRead, controller.GetItem)).Methods("GET")
+	router.Handle(APIVersion+"/instances", requires(auth.OpCreate, controller.CreateItem)).Methods("POST")
+	router.Handle(APIVersion+"/instances/import", requires(auth.OpCreate, controller.ImportItem)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}", requires(auth.OpResize, controller.UpdateItem)).Methods("PATCH")
+	router.Handle(APIVersion+"/instances/{id}", requires(auth.OpAdmin, controller.DeleteItem)).Methods("DELETE")
+	router.Handle(APIVersion+"/instances/{id}/usage", requires(auth.OpRead, controller.GetUsage)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/events", requires(auth.OpRead, controller.GetInstanceEvents)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/migrate", requires(auth.OpResize, controller.MigrateItem)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/export", requires(auth.OpRead, controller.ExportItem)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/snapshots", requires(auth.OpRead, controller.ListSnapshots)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/snapshots", requires(auth.OpCreate, controller.CreateSnapshot)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/snapshots/{snapshotId}", requires(auth.OpRead, controller.GetSnapshot)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/snapshots/{snapshotId}", requires(auth.OpDelete, controller.DeleteSnapshot)).Methods("DELETE")
+	router.Handle(APIVersion+"/instances/{id}/backups", requires(auth.OpRead, controller.ListBackups)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/backups", requires(auth.OpCreate, controller.CreateBackup)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/backups/{backupId}", requires(auth.OpRead, controller.GetBackup)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/backups/{backupId}", requires(auth.OpDelete, controller.DeleteBackup)).Methods("DELETE")
+	router.Handle(APIVersion+"/instances/{id}/backups/{backupId}/restore", requires(auth.OpResize, controller.RestoreBackup)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/restores", requires(auth.OpRead, controller.ListRestores)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/restores/{jobId}", requires(auth.OpRead, controller.GetRestore)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/backup-schedules", requires(auth.OpRead, controller.ListBackupSchedules)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/backup-schedules", requires(auth.OpCreate, controller.CreateBackupSchedule)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/backup-schedules/{scheduleId}", requires(auth.OpRead, controller.GetBackupSchedule)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/backup-schedules/{scheduleId}", requires(auth.OpResize, controller.UpdateBackupSchedule)).Methods("PUT")
+	router.Handle(APIVersion+"/instances/{id}/backup-schedules/{scheduleId}", requires(auth.OpDelete, controller.DeleteBackupSchedule)).Methods("DELETE")
+	router.Handle(APIVersion+"/instances/{id}/autoscale", requires(auth.OpRead, controller.GetAutoscalePolicy)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/autoscale", requires(auth.OpResize, controller.SetAutoscalePolicy)).Methods("PUT")
+	router.Handle(APIVersion+"/instances/{id}/autoscale", requires(auth.OpDelete, controller.DeleteAutoscalePolicy)).Methods("DELETE")
+
+	router.Handle(APIVersion+"/instances/{id}/buckets", requires(auth.OpRead, controller.GetBuckets)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/buckets", requires(auth.OpCreate, controller.CreateBucket)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}", requires(auth.OpRead, controller.GetBucket)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}", requires(auth.OpDelete, controller.DeleteBucket)).Methods("DELETE")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/quota", requires(auth.OpRead, controller.GetBucketQuota)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/quota", requires(auth.OpResize, controller.SetBucketQuota)).Methods("PUT")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/quota", requires(auth.OpDelete, controller.DeleteBucketQuota)).Methods("DELETE")
+
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/lifecycle", requires(auth.OpRead, controller.GetBucketLifecycle)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/lifecycle", requires(auth.OpResize, controller.SetBucketLifecycle)).Methods("PUT")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/lifecycle", requires(auth.OpDelete, controller.DeleteBucketLifecycle)).Methods("DELETE")
+
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/policy", requires(auth.OpRead, controller.GetBucketPolicy)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/policy", requires(auth.OpResize, controller.SetBucketPolicy)).Methods("PUT")
+
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/notifications", requires(auth.OpRead, controller.GetBucketNotifications)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/notifications", requires(auth.OpCreate, controller.CreateBucketNotification)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/notifications/{notificationId}", requires(auth.OpDelete, controller.DeleteBucketNotification)).Methods("DELETE")
+
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/presign", requires(auth.OpCreate, controller.PresignObject)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/replication", requires(auth.OpRead, controller.GetReplication)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/replication", requires(auth.OpCreate, controller.CreateReplication)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/buckets/{bucket}/replication", requires(auth.OpDelete, controller.DeleteReplication)).Methods("DELETE")
+
+	router.Handle(APIVersion+"/instances/{id}/notification-targets", requires(auth.OpRead, controller.GetNotificationTargets)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/notification-targets/{name}", requires(auth.OpResize, controller.SetNotificationTarget)).Methods("PUT")
+	router.Handle(APIVersion+"/instances/{id}/notification-targets/{name}", requires(auth.OpDelete, controller.DeleteNotificationTarget)).Methods("DELETE")
+
+	router.Handle(APIVersion+"/instances/{id}/users/{user}/service-accounts", requires(auth.OpRead, controller.GetServiceAccounts)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/users/{user}/service-accounts", requires(auth.OpCreate, controller.CreateServiceAccount)).Methods("POST")
+	router.Handle(APIVersion+"/instances/{id}/users/{user}/service-accounts/{accessKey}", requires(auth.OpRead, controller.GetServiceAccount)).Methods("GET")
+	router.Handle(APIVersion+"/instances/{id}/users/{user}/service-accounts/{accessKey}", requires(auth.OpResize, controller.UpdateServiceAccount)).Methods("PATCH")
+	router.Handle(APIVersion+"/instances/{id}/users/{user}/service-accounts/{accessKey}", requires(auth.OpDelete, controller.DeleteServiceAccount)).Methods("DELETE")
+
+	router.Handle(APIVersion+"/quota", requires(auth.OpRead, controller.GetQuota)).Methods("GET")
+	router.Handle(APIVersion+"/quota", requires(auth.OpAdmin, controller.SetQuota)).Methods("PUT")
+	router.Handle(APIVersion+"/quota", requires(auth.OpAdmin, controller.DeleteQuota)).Methods("DELETE")
+
+	router.Handle(APIVersion+"/audit", requires(auth.OpAdmin, controller.GetAuditLog)).Methods("GET")
+
+	router.Handle(APIVersion+"/webhooks", requires(auth.OpAdmin, controller.ListWebhooks)).Methods("GET")
+	router.Handle(APIVersion+"/webhooks", requires(auth.OpAdmin, controller.CreateWebhook)).Methods("POST")
+	router.Handle(APIVersion+"/webhooks/{webhookId}", requires(auth.OpAdmin, controller.GetWebhook)).Methods("GET")
+	router.Handle(APIVersion+"/webhooks/{webhookId}", requires(auth.OpAdmin, controller.DeleteWebhook)).Methods("DELETE")
+	router.Handle(APIVersion+"/webhooks/{webhookId}/deliveries", requires(auth.OpAdmin, controller.ListWebhookDeliveries)).Methods("GET")
+
+	router.Handle(APIVersion+"/apikeys", requires(auth.OpAdmin, controller.ListAPIKeys)).Methods("GET")
+	router.Handle(APIVersion+"/apikeys", requires(auth.OpAdmin, controller.CreateAPIKey)).Methods("POST")
+	router.Handle(APIVersion+"/apikeys/{keyId}", requires(auth.OpAdmin, controller.DeleteAPIKey)).Methods("DELETE")
 
 	return router
 }
@@ -55,6 +140,14 @@ func main() {
 
 	router := setupRouter()
 
+	autoscaleInterval, err := time.ParseDuration(os.Getenv("AUTOSCALE_INTERVAL"))
+	if err != nil || autoscaleInterval <= 0 {
+		autoscaleInterval = 5 * time.Minute
+	}
+	controller.StartAutoscaler(autoscaleInterval)
+	controller.StartBackupScheduler()
+	controller.StartWebhookDispatcher()
+
 	server := &http.Server{
 		Addr:    ":8080",
 		Handler: router,
@@ -87,20 +180,49 @@ func gracefulShutdown(server *http.Server) {
 	log.Println("Server shut down gracefully")
 }
 
-func apiKeyMiddleware(next http.Handler) http.Handler {
+// authMiddleware authenticates requests with a JWT bearer token when OIDC is
+// configured, and with the X-API-KEY header otherwise
+func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiKey, givenApiKey := os.Getenv("API_KEY"), r.Header.Get("X-API-KEY")
-
-		if apiKey == "" {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			return
+		var principal *auth.Principal
+		var err error
+		if token, ok := bearerToken(r); ok && auth.OIDCEnabled() {
+			principal, err = auth.AuthenticateToken(token)
+		} else {
+			principal, err = auth.AuthenticateAPIKey(r.Header.Get("X-API-KEY"))
 		}
-		if givenApiKey != apiKey {
+		if errors.Is(err, auth.ErrUnauthenticated) || errors.Is(err, auth.ErrExpired) {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// requires wraps a handler so it only runs for callers allowed to perform op
+func requires(op string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal := auth.FromContext(r.Context()); principal == nil || !principal.Allows(op) {
+			log.Printf("Denied %s %s to %v: requires %s", r.Method, r.URL.Path, principal, op)
+			// The response is recorded in the audit log by AuditMiddleware
+			http.Error(w, "Forbidden: requires "+op, http.StatusForbidden)
+			return
+		}
+		handler(w, r)
 	})
 }
 
@@ -108,8 +230,8 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		allowedOrigin := os.Getenv("ALLOWED_ORIGIN")
 		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, X-API-KEY")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, X-API-KEY, Authorization")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -1,5 +1,10 @@
 package model
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Credentials struct {
 	RandNum      string
 	RootUser     string
@@ -13,25 +18,392 @@ type Item struct {
 var Items []Item
 
 type Post struct {
-	Storage string `json:"storage"`
-	Bucket  string `json:"bucket"`
+	Storage      string `json:"storage"`
+	Bucket       string `json:"bucket"`
+	QuotaPercent int    `json:"quotapercent,omitempty"`
+	StorageClass string `json:"storageclass,omitempty"`
+	// SourceSnapshot or SourceInstance clone the data of the new instance
+	// from a snapshot or the volume of an existing instance, SourceBackup
+	// restores a backup into it once it is provisioned
+	SourceSnapshot string `json:"sourcesnapshot,omitempty"`
+	SourceInstance string `json:"sourceinstance,omitempty"`
+	SourceBackup   string `json:"sourcebackup,omitempty"`
+	BucketOptions
+}
+
+// BucketOptions are applied when a bucket is created
+type BucketOptions struct {
+	Versioning        bool   `json:"versioning,omitempty"`
+	ObjectLock        bool   `json:"objectlock,omitempty"`
+	RetentionMode     string `json:"retentionmode,omitempty"`
+	RetentionValidity uint   `json:"retentionvalidity,omitempty"`
+	RetentionUnit     string `json:"retentionunit,omitempty"`
+}
+
+type BucketPost struct {
+	Bucket string `json:"bucket"`
+	Quota  string `json:"quota,omitempty"`
+	BucketOptions
+}
+
+type Bucket struct {
+	Name              string `json:"name"`
+	Created           string `json:"created,omitempty"`
+	Versioning        string `json:"versioning,omitempty"`
+	ObjectLock        bool   `json:"objectlock"`
+	RetentionMode     string `json:"retentionmode,omitempty"`
+	RetentionValidity uint   `json:"retentionvalidity,omitempty"`
+	RetentionUnit     string `json:"retentionunit,omitempty"`
 }
 
 type Resp struct {
-	Status    string `json:"status,omitempty"`
-	ID        string `json:"id,omitempty"`
-	Storage   string `json:"storage,omitempty"`
-	Bucket    string `json:"bucket,omitempty"`
-	URL       string `json:"url,omitempty"`
-	AccessKey string `json:"accesskey,omitempty"`
-	SecretKey string `json:"secretkey,omitempty"`
+	Status       string `json:"status,omitempty"`
+	ID           string `json:"id,omitempty"`
+	Storage      string `json:"storage,omitempty"`
+	Bucket       string `json:"bucket,omitempty"`
+	URL          string `json:"url,omitempty"`
+	AccessKey    string `json:"accesskey,omitempty"`
+	SecretKey    string `json:"secretkey,omitempty"`
+	StorageClass string `json:"storageclass,omitempty"`
+	Tenant       string `json:"tenant,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+	QuotaPercent int    `json:"quotapercent,omitempty"`
 }
 
 type Record struct {
-	Status     string `json:"status,omitempty"`
-	Date       string `json:"date,omitempty"`
-	ID         string `json:"id,omitempty"`
-	InitBucket string `json:"initbucket,omitempty"`
-	URL        string `json:"url,omitempty"`
-	Storage    string `json:"storage,omitempty"`
+	Status       string `json:"status,omitempty"`
+	Date         string `json:"date,omitempty"`
+	ID           string `json:"id,omitempty"`
+	InitBucket   string `json:"initbucket,omitempty"`
+	URL          string `json:"url,omitempty"`
+	Storage      string `json:"storage,omitempty"`
+	StorageClass string `json:"storageclass,omitempty"`
+	Tenant       string `json:"tenant,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+	QuotaPercent int    `json:"quotapercent,omitempty"`
+}
+
+type MigratePost struct {
+	StorageClass string `json:"storageclass"`
+}
+
+type QuotaPost struct {
+	Quota string `json:"quota"`
+}
+
+type Quota struct {
+	Bucket string `json:"bucket"`
+	Quota  string `json:"quota,omitempty"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+type ServiceAccountPost struct {
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Policy      json.RawMessage `json:"policy,omitempty"`
+	Expiration  *time.Time      `json:"expiration,omitempty"`
+	Status      string          `json:"status,omitempty"`
+}
+
+type ServiceAccount struct {
+	AccessKey   string          `json:"accesskey,omitempty"`
+	SecretKey   string          `json:"secretkey,omitempty"`
+	ParentUser  string          `json:"parentuser,omitempty"`
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Status      string          `json:"status,omitempty"`
+	Policy      json.RawMessage `json:"policy,omitempty"`
+	Expiration  *time.Time      `json:"expiration,omitempty"`
+}
+
+type LifecycleRule struct {
+	ID                        string `json:"id"`
+	Status                    string `json:"status,omitempty"`
+	Prefix                    string `json:"prefix,omitempty"`
+	ExpirationDays            int    `json:"expirationdays,omitempty"`
+	ExpiredDeleteMarker       bool   `json:"expireddeletemarker,omitempty"`
+	NoncurrentExpirationDays  int    `json:"noncurrentexpirationdays,omitempty"`
+	NewerNoncurrentVersions   int    `json:"newernoncurrentversions,omitempty"`
+	AbortIncompleteUploadDays int    `json:"abortincompleteuploaddays,omitempty"`
+}
+
+type LifecyclePost struct {
+	Rules []LifecycleRule `json:"rules"`
+}
+
+type BucketPolicy struct {
+	Preset string          `json:"preset,omitempty"`
+	Policy json.RawMessage `json:"policy,omitempty"`
+}
+
+type NotificationTarget struct {
+	Name      string `json:"name"`
+	Endpoint  string `json:"endpoint"`
+	AuthToken string `json:"authtoken,omitempty"`
+	ARN       string `json:"arn,omitempty"`
+}
+
+type BucketNotification struct {
+	ID     string   `json:"id,omitempty"`
+	Target string   `json:"target"`
+	ARN    string   `json:"arn,omitempty"`
+	Events []string `json:"events"`
+	Prefix string   `json:"prefix,omitempty"`
+	Suffix string   `json:"suffix,omitempty"`
+}
+
+type ReplicationPost struct {
+	TargetInstance string `json:"targetinstance"`
+	TargetBucket   string `json:"targetbucket"`
+}
+
+type ReplicationTarget struct {
+	ARN             string `json:"arn"`
+	TargetInstance  string `json:"targetinstance"`
+	TargetBucket    string `json:"targetbucket"`
+	Online          bool   `json:"online"`
+	LastOnline      string `json:"lastonline,omitempty"`
+	LagCurrentMs    int64  `json:"lagcurrentms"`
+	LagAverageMs    int64  `json:"lagaveragems"`
+	LagMaxMs        int64  `json:"lagmaxms"`
+	ReplicatedCount uint64 `json:"replicatedcount"`
+	ReplicatedBytes uint64 `json:"replicatedbytes"`
+	PendingCount    uint64 `json:"pendingcount"`
+	PendingBytes    uint64 `json:"pendingbytes"`
+	FailedCount     uint64 `json:"failedcount"`
+	FailedBytes     uint64 `json:"failedbytes"`
+}
+
+type ReplicationStatus struct {
+	SourceInstance string              `json:"sourceinstance"`
+	SourceBucket   string              `json:"sourcebucket"`
+	QueuedCount    float64             `json:"queuedcount"`
+	QueuedBytes    float64             `json:"queuedbytes"`
+	Targets        []ReplicationTarget `json:"targets"`
+}
+
+type PresignPost struct {
+	Object      string `json:"object"`
+	Method      string `json:"method"`
+	Expiry      int    `json:"expiry,omitempty"`
+	ContentType string `json:"contenttype,omitempty"`
+	MaxSize     int64  `json:"maxsize,omitempty"`
+}
+
+type Presigned struct {
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Expires  string            `json:"expires"`
+	FormData map[string]string `json:"formdata,omitempty"`
+}
+
+type BucketUsage struct {
+	Name          string `json:"name"`
+	Bytes         uint64 `json:"bytes"`
+	ObjectsCount  uint64 `json:"objectscount"`
+	VersionsCount uint64 `json:"versionscount"`
+}
+
+type Usage struct {
+	ID            string        `json:"id"`
+	TotalBytes    uint64        `json:"totalbytes"`
+	ObjectsCount  uint64        `json:"objectscount"`
+	BucketsCount  uint64        `json:"bucketscount"`
+	Capacity      string        `json:"capacity"`
+	CapacityBytes int64         `json:"capacitybytes"`
+	PercentUsed   float64       `json:"percentused"`
+	LastUpdate    string        `json:"lastupdate,omitempty"`
+	Buckets       []BucketUsage `json:"buckets"`
+}
+
+type Event struct {
+	Date    string `json:"date"`
+	Type    string `json:"type"`
+	Message string `json:"message,omitempty"`
+}
+
+type AutoscalePolicy struct {
+	ID               string  `json:"id,omitempty"`
+	ThresholdPercent int     `json:"thresholdpercent"`
+	Step             string  `json:"step"`
+	MaxStorage       string  `json:"maxstorage"`
+	History          []Event `json:"history,omitempty"`
+}
+
+type SnapshotPost struct {
+	Retain bool `json:"retain"`
+}
+
+type Snapshot struct {
+	ID            string `json:"id"`
+	InstanceID    string `json:"instance"`
+	Date          string `json:"date,omitempty"`
+	SnapshotClass string `json:"snapshotclass,omitempty"`
+	Storage       string `json:"storage"`
+	Retain        bool   `json:"retain"`
+	ReadyToUse    bool   `json:"readytouse"`
+	RestoreSize   string `json:"restoresize,omitempty"`
+	Error         string `json:"error,omitempty"`
+	ScheduleID    string `json:"schedule,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	Tenant        string `json:"tenant,omitempty"`
+	RootUser      string `json:"-"`
+}
+
+// BackupJob is a backup of an instance to the backup target, or a restore of
+// such a backup into an instance
+type BackupJob struct {
+	ID         string `json:"id"`
+	InstanceID string `json:"instance"`
+	Type       string `json:"type"`
+	BackupID   string `json:"backup,omitempty"`
+	Prefix     string `json:"prefix"`
+	Status     string `json:"status"`
+	Date       string `json:"date"`
+	Finished   string `json:"finished,omitempty"`
+	Objects    int64  `json:"objects"`
+	Bytes      int64  `json:"bytes"`
+	Error      string `json:"error,omitempty"`
+	ScheduleID string `json:"schedule,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+}
+
+type RestorePost struct {
+	Instance string `json:"instance"`
+}
+
+// BackupSchedule takes a backup or snapshot of an instance on a cron schedule
+// and keeps the newest one of the last KeepDaily days and KeepWeekly weeks
+type BackupSchedule struct {
+	ID         string        `json:"id,omitempty"`
+	InstanceID string        `json:"instance,omitempty"`
+	Schedule   string        `json:"schedule"`
+	Type       string        `json:"type"`
+	KeepDaily  int           `json:"keepdaily"`
+	KeepWeekly int           `json:"keepweekly"`
+	NextRun    string        `json:"nextrun,omitempty"`
+	Runs       []ScheduleRun `json:"runs,omitempty"`
+}
+
+type ScheduleRun struct {
+	Date     string `json:"date"`
+	Artifact string `json:"artifact,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Export is the instance definition stored as instance.json in an export
+// archive, ahead of the bucket objects
+type Export struct {
+	Instance Record         `json:"instance"`
+	Buckets  []BucketExport `json:"buckets"`
+}
+
+// BucketExport holds the settings of a bucket needed to recreate it
+type BucketExport struct {
+	Name string `json:"name"`
+	BucketOptions
+	Policy    json.RawMessage   `json:"policy,omitempty"`
+	Lifecycle string            `json:"lifecycle,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Quota     uint64            `json:"quota,omitempty"`
+}
+
+// APIKey is a key clients authenticate with. The key itself is only returned
+// when it is issued, only its hash is stored.
+type APIKey struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Tenant     string   `json:"tenant,omitempty"`
+	Role       string   `json:"role,omitempty"`
+	Operations []string `json:"operations,omitempty"`
+	Expires    string   `json:"expires,omitempty"`
+	Created    string   `json:"created,omitempty"`
+	Key        string   `json:"key,omitempty"`
+}
+
+// TenantQuota limits the instances of a tenant. Zero or empty values are
+// unlimited.
+type TenantQuota struct {
+	Tenant             string            `json:"tenant"`
+	MaxInstances       int               `json:"maxinstances"`
+	MaxStorage         string            `json:"maxstorage,omitempty"`
+	MaxInstanceStorage string            `json:"maxinstancestorage,omitempty"`
+	ResourceQuota      map[string]string `json:"resourcequota,omitempty"`
+	LimitRange         *LimitRange       `json:"limitrange,omitempty"`
+}
+
+// LimitRange holds the container defaults and bounds of a tenant namespace
+type LimitRange struct {
+	Default        map[string]string `json:"default,omitempty"`
+	DefaultRequest map[string]string `json:"defaultrequest,omitempty"`
+	Max            map[string]string `json:"max,omitempty"`
+}
+
+// Namespace is the namespace of an instance with the policy applied to it
+type Namespace struct {
+	Name          string
+	ResourceQuota map[string]string
+	LimitRange    *LimitRange
+}
+
+// QuotaUsage is the usage of a tenant next to its limits
+type QuotaUsage struct {
+	TenantQuota
+	Instances int    `json:"instances"`
+	Storage   string `json:"storage"`
+}
+
+// AuditEntry records an API action, who performed it and how it ended
+type AuditEntry struct {
+	Seq        int64  `json:"seq"`
+	Date       string `json:"date"`
+	Caller     string `json:"caller"`
+	CallerName string `json:"callername,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Action     string `json:"action"`
+	InstanceID string `json:"instance,omitempty"`
+	Payload    string `json:"payload,omitempty"`
+	Status     int    `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Webhook subscribes a URL to lifecycle events of the instances of a
+// tenant. The secret signs every delivery and is only returned when the
+// webhook is created.
+type Webhook struct {
+	ID      string   `json:"id"`
+	Tenant  string   `json:"tenant,omitempty"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret,omitempty"`
+	Events  []string `json:"events"`
+	Created string   `json:"created,omitempty"`
+}
+
+// WebhookPayload is the JSON body posted to a webhook
+type WebhookPayload struct {
+	Event    string `json:"event"`
+	Instance string `json:"instance"`
+	Tenant   string `json:"tenant,omitempty"`
+	Date     string `json:"date"`
+	Message  string `json:"message,omitempty"`
+}
+
+// WebhookDelivery is an attempt to post a payload to a webhook, retried
+// until it succeeds or runs out of attempts
+type WebhookDelivery struct {
+	ID           int64           `json:"id"`
+	Webhook      string          `json:"webhook"`
+	Event        string          `json:"event"`
+	Instance     string          `json:"instance"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  string          `json:"nextattempt,omitempty"`
+	ResponseCode int             `json:"responsecode,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Created      string          `json:"created"`
+	URL          string          `json:"-"`
+	Secret       string          `json:"-"`
 }